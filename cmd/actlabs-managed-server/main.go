@@ -3,19 +3,31 @@ package main
 import (
 	"actlabs-managed-server/internal/auth"
 	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
 	"actlabs-managed-server/internal/handler"
 	"actlabs-managed-server/internal/logger"
 	"actlabs-managed-server/internal/middleware"
 	"actlabs-managed-server/internal/redis"
 	"actlabs-managed-server/internal/repository"
 	"actlabs-managed-server/internal/service"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/exp/slog"
 )
 
+const shutdownTimeout = 2 * time.Minute
+
+var allowedOrigins = []string{"http://localhost:3000", "http://localhost:5173", "https://ashisverma.z13.web.core.windows.net", "https://actlabs.z13.web.core.windows.net", "https://actlabsbeta.z13.web.core.windows.net", "https://actlabs.azureedge.net", "https://*.azurewebsites.net"}
+
 func main() {
 	logger.SetupLogger()
 	appConfig, err := config.NewConfig()
@@ -31,37 +43,155 @@ func main() {
 	}
 
 	rateLimiter := redis.NewRateLimiter(rdb)
+	locker := redis.NewLocker(rdb)
 
-	auth, err := auth.NewAuth(appConfig)
-	if err != nil {
-		slog.Error("Error initializing auth", err)
-		panic(err)
-	}
-
-	serverRepository, err := repository.NewServerRepository(appConfig, auth)
+	repositories, err := newRepositories(appConfig)
 	if err != nil {
 		slog.Error("Error initializing server repository", err)
 		panic(err)
 	}
+	serverRepository := repositories.server
+	onboardingRepository := repositories.onboarding
+
+	operationRepository := repository.NewOperationRepository(rdb)
+	releaseChannelRepository := repository.NewReleaseChannelRepository(rdb)
+	worker := service.NewWorker(appConfig.DeployWorkerCount, appConfig.DeployQueueSize)
+
+	serverService := service.NewServerService(serverRepository, operationRepository, onboardingRepository, repositories.event, repositories.usage, locker, releaseChannelRepository, worker, appConfig)
+	operationService := service.NewOperationService(operationRepository)
+	terminalService := service.NewTerminalService(serverRepository, repository.NewTerminalRepository(rdb), appConfig)
+	releaseChannelService := service.NewReleaseChannelService(releaseChannelRepository, serverRepository, appConfig)
+	usageService := service.NewUsageService(repositories.usage, serverRepository, appConfig)
+	adminService := service.NewAdminService(serverRepository, operationRepository, repositories.event, repositories.usage, locker, appConfig)
+	onboardingService := service.NewOnboardingService(onboardingRepository, serverRepository, operationRepository, repositories.event, repositories.usage, locker, worker, appConfig)
 
-	serverService := service.NewServerService(serverRepository, appConfig)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	if appConfig.ReaperEnabled {
+		reaperService := service.NewReaperService(serverRepository, repositories.event, repositories.usage, locker, appConfig)
+		go reaperService.Start(ctx)
+	}
 
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
 
 	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:3000", "http://localhost:5173", "https://ashisverma.z13.web.core.windows.net", "https://actlabs.z13.web.core.windows.net", "https://actlabsbeta.z13.web.core.windows.net", "https://actlabs.azureedge.net", "https://*.azurewebsites.net"}
+	config.AllowOrigins = allowedOrigins
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Authorization", "Content-Type"}
+	config.AllowHeaders = []string{"Authorization", "Content-Type", "X-Request-Id"}
+	config.ExposeHeaders = []string{"X-Request-Id"}
 
 	router.Use(cors.New(config))
+	router.Use(middleware.RequestId())
 	router.Use(middleware.Auth(rateLimiter))
+	router.Use(middleware.Authorize(appConfig.RolePermissions))
+
+	// Every admin route is in this group, each route requires its own permission on top.
+	admin := router.Group("/admin", middleware.RequireAny(entity.Permissions...))
 
 	handler.NewServerHandler(router.Group("/"), serverService)
+	handler.NewOperationHandler(router.Group("/"), operationService)
+	handler.NewTerminalHandler(router.Group("/"), terminalService, appConfig, allowedOrigins)
+	handler.NewOnboardingHandler(router.Group("/"), onboardingService)
+	handler.NewReleaseChannelHandler(router.Group("/"), admin, releaseChannelService)
+	handler.NewUsageHandler(router.Group("/"), admin, usageService)
+	handler.NewAdminHandler(admin, adminService)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8883"
 	}
-	router.Run(":" + port)
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error running server", err)
+			panic(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	slog.Info("Shutting down server")
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down server", err)
+	}
+
+	// Give in-flight deployments a chance to finish so their operations don't stay running forever.
+	if err := worker.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error waiting for background operations", err)
+	}
+}
+
+// repositories that depend on where the servers run and are stored.
+type repositories struct {
+	server     entity.ServerRepository
+	onboarding entity.OnboardingRepository
+	event      entity.EventRepository
+	usage      entity.UsageRepository
+}
+
+func newRepositories(appConfig *config.Config) (repositories, error) {
+	// Nothing in Azure is touched when running locally.
+	if appConfig.ComputeBackend == entity.BackendDocker {
+		serverStore, err := repository.NewServerStore(appConfig, nil)
+		if err != nil {
+			return repositories{}, err
+		}
+		eventStore, err := repository.NewEventStore(appConfig, nil)
+		if err != nil {
+			return repositories{}, err
+		}
+		usageStore, err := repository.NewUsageStore(appConfig, nil)
+		if err != nil {
+			return repositories{}, err
+		}
+		serverRepository, err := repository.NewLocalServerRepository(appConfig, serverStore)
+		return repositories{
+			server:     serverRepository,
+			onboarding: repository.NewLocalOnboardingRepository(),
+			event:      eventStore,
+			usage:      usageStore,
+		}, err
+	}
+
+	auth, err := auth.NewAuth(appConfig)
+	if err != nil {
+		return repositories{}, fmt.Errorf("error initializing auth %w", err)
+	}
+
+	serverStore, err := repository.NewServerStore(appConfig, auth.Cred)
+	if err != nil {
+		return repositories{}, err
+	}
+
+	eventStore, err := repository.NewEventStore(appConfig, auth.Cred)
+	if err != nil {
+		return repositories{}, err
+	}
+
+	usageStore, err := repository.NewUsageStore(appConfig, auth.Cred)
+	if err != nil {
+		return repositories{}, err
+	}
+
+	serverRepository, err := repository.NewServerRepository(appConfig, auth, serverStore)
+	return repositories{
+		server:     serverRepository,
+		onboarding: repository.NewOnboardingRepository(auth),
+		event:      eventStore,
+		usage:      usageStore,
+	}, err
 }
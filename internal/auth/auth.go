@@ -12,8 +12,7 @@ import (
 )
 
 type Auth struct {
-	Cred                      azcore.TokenCredential
-	ActlabsServersTableClient *aztables.Client
+	Cred azcore.TokenCredential
 }
 
 func NewAuth(appConfig *config.Config) (*Auth, error) {
@@ -34,20 +33,8 @@ func NewAuth(appConfig *config.Config) (*Auth, error) {
 		}
 	}
 
-	tableClient, err := GetTableClient(
-		appConfig.ActlabsSubscriptionID,
-		cred,
-		appConfig.ActlabsResourceGroup,
-		appConfig.ActlabsStorageAccount,
-		appConfig.ActlabsServerTableName,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("not able to create table client %w", err)
-	}
-
 	return &Auth{
-		Cred:                      cred,
-		ActlabsServersTableClient: tableClient,
+		Cred: cred,
 	}, nil
 }
 
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"golang.org/x/exp/slog"
@@ -36,6 +42,38 @@ type Config struct {
 	ActlabsResourceGroup                     string
 	ActlabsStorageAccount                    string
 	ActlabsServerTableName                   string
+	ReaperEnabled                            bool
+	ReaperIntervalSeconds                    int
+	ReaperDryRun                             bool
+	DefaultIdleAction                        string
+	DeployWorkerCount                        int
+	DeployQueueSize                          int
+	TerminalSessionTimeoutMinutes            int
+	ComputeBackend                           string
+	ContainerAppsEnvironmentName             string
+	DockerHost                               string
+	ServerStore                              string
+	ServerStoreDSN                           string
+	ManagedIdentityRoles                     []string
+	RoleAssignmentTimeoutSeconds             int
+	PreflightOnDeploy                        bool
+	ActlabsServerEventsTableName             string
+	RolePermissions                          map[string][]entity.Permission
+	ServerLockTTLSeconds                     int
+	AllowedRegions                           []string
+	DefaultRegion                            string
+	InitImage                                string
+	CaddyImage                               string
+	ReleaseChannels                          map[string]string
+	DefaultReleaseChannel                    string
+	ServerSizes                              []entity.ServerSize
+	DefaultServerSize                        string
+	MaxServerSize                            string
+	ServerSizeLimits                         map[string]string
+	ActlabsServerUsageTableName              string
+	UsagePrices                              map[string]entity.UsagePrice
+	UsageCurrency                            string
+	Policy                                   *entity.Policy
 	// Add other configuration fields as needed
 }
 
@@ -177,6 +215,272 @@ func NewConfig() (*Config, error) {
 		return nil, fmt.Errorf("ACTLABS_SERVER_TABLE_NAME not set")
 	}
 
+	reaperEnabled, err := strconv.ParseBool(getEnvWithDefault("REAPER_ENABLED", "true"))
+	if err != nil {
+		return nil, err
+	}
+
+	reaperIntervalSeconds, err := strconv.Atoi(getEnvWithDefault("REAPER_INTERVAL_SECONDS", "300"))
+	if err != nil {
+		return nil, err
+	}
+	if reaperIntervalSeconds <= 0 {
+		return nil, fmt.Errorf("REAPER_INTERVAL_SECONDS must be greater than 0")
+	}
+
+	// The reaper only logs what it would do until REAPER_DRY_RUN is turned off, destroying
+	// idle servers is opt-in.
+	reaperDryRun, err := strconv.ParseBool(getEnvWithDefault("REAPER_DRY_RUN", "true"))
+	if err != nil {
+		return nil, err
+	}
+
+	defaultIdleAction := getEnvWithDefault("DEFAULT_IDLE_ACTION", "destroy")
+	if defaultIdleAction != "destroy" && defaultIdleAction != "stop" {
+		return nil, fmt.Errorf("DEFAULT_IDLE_ACTION must be either destroy or stop")
+	}
+
+	deployWorkerCount, err := strconv.Atoi(getEnvWithDefault("DEPLOY_WORKER_COUNT", "4"))
+	if err != nil {
+		return nil, err
+	}
+	if deployWorkerCount <= 0 {
+		return nil, fmt.Errorf("DEPLOY_WORKER_COUNT must be greater than 0")
+	}
+
+	deployQueueSize, err := strconv.Atoi(getEnvWithDefault("DEPLOY_QUEUE_SIZE", "50"))
+	if err != nil {
+		return nil, err
+	}
+
+	terminalSessionTimeoutMinutes, err := strconv.Atoi(getEnvWithDefault("TERMINAL_SESSION_TIMEOUT_MINUTES", "30"))
+	if err != nil {
+		return nil, err
+	}
+	if terminalSessionTimeoutMinutes <= 0 {
+		return nil, fmt.Errorf("TERMINAL_SESSION_TIMEOUT_MINUTES must be greater than 0")
+	}
+
+	computeBackend := getEnvWithDefault("COMPUTE_BACKEND", "aci")
+	if computeBackend != "aci" && computeBackend != "containerapps" && computeBackend != "docker" {
+		return nil, fmt.Errorf("COMPUTE_BACKEND must be one of aci, containerapps or docker")
+	}
+
+	containerAppsEnvironmentName := getEnvWithDefault("CONTAINER_APPS_ENVIRONMENT_NAME", "actlabs-env")
+	if containerAppsEnvironmentName == "" {
+		return nil, fmt.Errorf("CONTAINER_APPS_ENVIRONMENT_NAME not set")
+	}
+
+	dockerHost := getEnvWithDefault("DOCKER_HOST", "unix:///var/run/docker.sock")
+	if dockerHost == "" {
+		return nil, fmt.Errorf("DOCKER_HOST not set")
+	}
+
+	// There is no storage account to keep the servers in when running locally.
+	defaultServerStore := "table"
+	if computeBackend == "docker" {
+		defaultServerStore = "sqlite"
+	}
+	serverStore := getEnvWithDefault("SERVER_STORE", defaultServerStore)
+	if serverStore != "table" && serverStore != "sqlite" && serverStore != "postgres" {
+		return nil, fmt.Errorf("SERVER_STORE must be one of table, sqlite or postgres")
+	}
+	if serverStore == "table" && computeBackend == "docker" {
+		return nil, fmt.Errorf("SERVER_STORE must be sqlite or postgres when COMPUTE_BACKEND is docker")
+	}
+
+	defaultServerStoreDSN := ""
+	if serverStore == "sqlite" {
+		defaultServerStoreDSN = "actlabs-managed-server.db"
+	}
+	// Not logged, a postgres DSN carries the password.
+	serverStoreDSN := os.Getenv("SERVER_STORE_DSN")
+	if serverStoreDSN == "" {
+		serverStoreDSN = defaultServerStoreDSN
+	}
+	if serverStoreDSN == "" && serverStore != "table" {
+		return nil, fmt.Errorf("SERVER_STORE_DSN not set")
+	}
+
+	// Role definition ids granted to the user's managed identity on their subscription,
+	// Contributor and User Access Administrator by default like scripts/setup.sh.
+	managedIdentityRoles := []string{}
+	for _, role := range strings.Split(getEnvWithDefault("MANAGED_IDENTITY_ROLES", "b24988ac-6180-42a0-ab88-20f7382dd24c,18d7d88d-d35e-4fb5-a5c3-7773c20a72d9"), ",") {
+		if role = strings.ToLower(strings.TrimSpace(role)); role != "" {
+			managedIdentityRoles = append(managedIdentityRoles, role)
+		}
+	}
+
+	roleAssignmentTimeoutSeconds, err := strconv.Atoi(getEnvWithDefault("ROLE_ASSIGNMENT_TIMEOUT_SECONDS", "300"))
+	if err != nil {
+		return nil, fmt.Errorf("ROLE_ASSIGNMENT_TIMEOUT_SECONDS must be a number")
+	}
+
+	preflightOnDeploy, err := strconv.ParseBool(getEnvWithDefault("PREFLIGHT_ON_DEPLOY", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("PREFLIGHT_ON_DEPLOY must be true or false")
+	}
+
+	actlabsServerEventsTableName := getEnvWithDefault("ACTLABS_SERVER_EVENTS_TABLE_NAME", "ActlabsServerEvents")
+
+	// App role in the token that lets a user read and manage other users' servers.
+	adminRole := getEnvWithDefault("ADMIN_ROLE", "Admin")
+
+	// Members of these groups are admins without the app role.
+	adminGroups := []string{}
+	for _, group := range strings.Split(getEnv("ADMIN_GROUPS"), ",") {
+		if group = strings.ToLower(strings.TrimSpace(group)); group != "" {
+			adminGroups = append(adminGroups, group)
+		}
+	}
+
+	// Admins have every permission. ROLE_PERMISSIONS grants permissions to other app roles
+	// and groups, or changes the admins', with "<role or group>=<permission>|<permission>"
+	// pairs.
+	rolePermissions := map[string][]entity.Permission{
+		strings.ToLower(adminRole): {entity.PermissionAll},
+	}
+	for _, group := range adminGroups {
+		rolePermissions[group] = []entity.Permission{entity.PermissionAll}
+	}
+	for _, grant := range strings.Split(getEnv("ROLE_PERMISSIONS"), ",") {
+		if strings.TrimSpace(grant) == "" {
+			continue
+		}
+		name, permissions, ok := strings.Cut(strings.TrimSpace(grant), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("ROLE_PERMISSIONS must be a comma separated list of <role or group>=<permission>|<permission>")
+		}
+		granted := []entity.Permission{}
+		for _, permission := range strings.Split(permissions, "|") {
+			if permission = strings.TrimSpace(permission); permission == "" {
+				continue
+			}
+			if !entity.IsPermission(entity.Permission(permission)) {
+				return nil, fmt.Errorf("ROLE_PERMISSIONS has unknown permission %s, permissions are %v", permission, entity.Permissions)
+			}
+			granted = append(granted, entity.Permission(permission))
+		}
+		rolePermissions[strings.ToLower(strings.TrimSpace(name))] = granted
+	}
+
+	// The per-user lock is renewed while an operation runs, the ttl only matters when the
+	// replica holding it goes away.
+	serverLockTTLSeconds, err := strconv.Atoi(getEnvWithDefault("SERVER_LOCK_TTL_SECONDS", "60"))
+	if err != nil || serverLockTTLSeconds < 3 {
+		return nil, fmt.Errorf("SERVER_LOCK_TTL_SECONDS must be a number of at least 3")
+	}
+
+	// Regions are kept as ARM names, either form is accepted here and in requests.
+	allowedRegions := []string{}
+	for _, region := range strings.Split(getEnvWithDefault("ALLOWED_REGIONS", "eastus,eastus2,westus2,westus3,centralus,northeurope,westeurope,uksouth,southeastasia,australiaeast"), ",") {
+		if region = helper.NormalizeRegion(region); region != "" {
+			allowedRegions = append(allowedRegions, region)
+		}
+	}
+	if len(allowedRegions) == 0 {
+		return nil, fmt.Errorf("ALLOWED_REGIONS must have at least one region")
+	}
+
+	defaultRegion := helper.NormalizeRegion(getEnvWithDefault("DEFAULT_REGION", "eastus"))
+	if !helper.Contains(allowedRegions, defaultRegion) {
+		return nil, fmt.Errorf("DEFAULT_REGION %s is not in ALLOWED_REGIONS", defaultRegion)
+	}
+
+	initImage := getEnvWithDefault("INIT_IMAGE", "busybox:latest")
+	caddyImage := getEnvWithDefault("CADDY_IMAGE", "ashishvermapu/caddy:latest")
+
+	// Release channels are "<name>=<image>" pairs. These are the images a channel starts
+	// with, admins can change them at runtime.
+	releaseChannels := map[string]string{}
+	for _, channel := range strings.Split(getEnvWithDefault("RELEASE_CHANNELS", "stable=ashishvermapu/repro:latest,beta=ashishvermapu/repro:beta,alpha=ashishvermapu/repro:alpha"), ",") {
+		name, image, ok := strings.Cut(strings.TrimSpace(channel), "=")
+		if !ok || name == "" || image == "" {
+			return nil, fmt.Errorf("RELEASE_CHANNELS must be a comma separated list of <name>=<image>")
+		}
+		releaseChannels[name] = image
+	}
+
+	// alpha is the image servers were deployed with before channels existed.
+	defaultReleaseChannel := getEnvWithDefault("DEFAULT_RELEASE_CHANNEL", "alpha")
+	if _, ok := releaseChannels[defaultReleaseChannel]; !ok {
+		return nil, fmt.Errorf("DEFAULT_RELEASE_CHANNEL %s is not in RELEASE_CHANNELS", defaultReleaseChannel)
+	}
+
+	// Sizes are "<name>=<cpu>:<memory>" pairs, smallest first. The order is what the
+	// size limits compare. The default small size is the actlabs container of before.
+	serverSizes := []entity.ServerSize{}
+	for _, size := range strings.Split(getEnvWithDefault("SERVER_SIZES", fmt.Sprintf("small=%g:%g,medium=1:2,large=2:4", actlabsCPUFloat, actlabsMemoryFloat)), ",") {
+		name, resources, _ := strings.Cut(strings.TrimSpace(size), "=")
+		cpu, memory, ok := strings.Cut(resources, ":")
+		cpuFloat, cpuErr := strconv.ParseFloat(cpu, 64)
+		memoryFloat, memoryErr := strconv.ParseFloat(memory, 64)
+		if !ok || name == "" || cpuErr != nil || memoryErr != nil {
+			return nil, fmt.Errorf("SERVER_SIZES must be a comma separated list of <name>=<cpu>:<memory>")
+		}
+		serverSizes = append(serverSizes, entity.ServerSize{Name: name, CPU: cpuFloat, Memory: memoryFloat})
+	}
+
+	hasServerSize := func(name string) bool {
+		for _, size := range serverSizes {
+			if size.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	defaultServerSize := getEnvWithDefault("DEFAULT_SERVER_SIZE", serverSizes[0].Name)
+	if !hasServerSize(defaultServerSize) {
+		return nil, fmt.Errorf("DEFAULT_SERVER_SIZE %s is not in SERVER_SIZES", defaultServerSize)
+	}
+
+	// The largest size users may pick unless a limit for them or their group says otherwise.
+	maxServerSize := getEnvWithDefault("MAX_SERVER_SIZE", defaultServerSize)
+	if !hasServerSize(maxServerSize) {
+		return nil, fmt.Errorf("MAX_SERVER_SIZE %s is not in SERVER_SIZES", maxServerSize)
+	}
+
+	// Limits are "<object id>=<size>" pairs, the object id of a user or of a group in the
+	// token's groups claim.
+	serverSizeLimits := map[string]string{}
+	if limits := getEnv("SERVER_SIZE_LIMITS"); limits != "" {
+		for _, limit := range strings.Split(limits, ",") {
+			objectId, size, ok := strings.Cut(strings.TrimSpace(limit), "=")
+			if !ok || objectId == "" || !hasServerSize(size) {
+				return nil, fmt.Errorf("SERVER_SIZE_LIMITS must be a comma separated list of <object id>=<size> with sizes from SERVER_SIZES")
+			}
+			serverSizeLimits[strings.ToLower(objectId)] = size
+		}
+	}
+
+	actlabsServerUsageTableName := getEnvWithDefault("ACTLABS_SERVER_USAGE_TABLE_NAME", "ActlabsServerUsage")
+
+	// Prices are "<backend>=<cpu hour>:<memory GB hour>" pairs. The defaults are the pay as
+	// you go prices of Linux container groups and consumption container apps in East US.
+	usagePrices := map[string]entity.UsagePrice{}
+	for _, price := range strings.Split(getEnvWithDefault("USAGE_PRICES", "aci=0.0486:0.00533,containerapps=0.0864:0.0108,docker=0:0"), ",") {
+		backend, prices, _ := strings.Cut(strings.TrimSpace(price), "=")
+		cpu, memory, ok := strings.Cut(prices, ":")
+		cpuFloat, cpuErr := strconv.ParseFloat(cpu, 64)
+		memoryFloat, memoryErr := strconv.ParseFloat(memory, 64)
+		if !ok || backend == "" || cpuErr != nil || memoryErr != nil {
+			return nil, fmt.Errorf("USAGE_PRICES must be a comma separated list of <backend>=<cpu hour>:<memory GB hour>")
+		}
+		usagePrices[backend] = entity.UsagePrice{CPUHour: cpuFloat, MemoryGBHour: memoryFloat}
+	}
+
+	usageCurrency := getEnvWithDefault("USAGE_CURRENCY", "USD")
+
+	// Servers are only restricted by the other settings when there is no policy file.
+	var policy *entity.Policy
+	if policyFile := getEnv("POLICY_FILE"); policyFile != "" {
+		var err error
+		if policy, err = loadPolicy(policyFile); err != nil {
+			return nil, err
+		}
+	}
+
 	// Retrieve other environment variables and check them as needed
 
 	return &Config{
@@ -206,10 +510,108 @@ func NewConfig() (*Config, error) {
 		ActlabsResourceGroup:                     actlabsResourceGroup,
 		ActlabsStorageAccount:                    actlabsStorageAccount,
 		ActlabsServerTableName:                   actlabsServerTableName,
+		ReaperEnabled:                            reaperEnabled,
+		ReaperIntervalSeconds:                    reaperIntervalSeconds,
+		ReaperDryRun:                             reaperDryRun,
+		DefaultIdleAction:                        defaultIdleAction,
+		DeployWorkerCount:                        deployWorkerCount,
+		DeployQueueSize:                          deployQueueSize,
+		TerminalSessionTimeoutMinutes:            terminalSessionTimeoutMinutes,
+		ComputeBackend:                           computeBackend,
+		ContainerAppsEnvironmentName:             containerAppsEnvironmentName,
+		DockerHost:                               dockerHost,
+		ServerStore:                              serverStore,
+		ServerStoreDSN:                           serverStoreDSN,
+		ManagedIdentityRoles:                     managedIdentityRoles,
+		RoleAssignmentTimeoutSeconds:             roleAssignmentTimeoutSeconds,
+		PreflightOnDeploy:                        preflightOnDeploy,
+		ActlabsServerEventsTableName:             actlabsServerEventsTableName,
+		RolePermissions:                          rolePermissions,
+		ServerLockTTLSeconds:                     serverLockTTLSeconds,
+		AllowedRegions:                           allowedRegions,
+		DefaultRegion:                            defaultRegion,
+		InitImage:                                initImage,
+		CaddyImage:                               caddyImage,
+		ReleaseChannels:                          releaseChannels,
+		DefaultReleaseChannel:                    defaultReleaseChannel,
+		ServerSizes:                              serverSizes,
+		DefaultServerSize:                        defaultServerSize,
+		MaxServerSize:                            maxServerSize,
+		ServerSizeLimits:                         serverSizeLimits,
+		ActlabsServerUsageTableName:              actlabsServerUsageTableName,
+		UsagePrices:                              usagePrices,
+		UsageCurrency:                            usageCurrency,
+		Policy:                                   policy,
 		// Set other fields
 	}, nil
 }
 
+// ServerSize is the configured size of the name. Servers deployed before sizes existed
+// get the default size.
+func (c *Config) ServerSize(name string) entity.ServerSize {
+	if name == "" {
+		name = c.DefaultServerSize
+	}
+
+	for _, size := range c.ServerSizes {
+		if size.Name == name {
+			return size
+		}
+	}
+
+	return entity.ServerSize{Name: name, CPU: c.ActlabsCPU, Memory: c.ActlabsMemory}
+}
+
+// loadPolicy reads the policy file. Regions, subscriptions and object ids are normalized
+// so that they compare like the rest of the config.
+func loadPolicy(file string) (*entity.Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read POLICY_FILE %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	policy := &entity.Policy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("not able to parse POLICY_FILE %w", err)
+	}
+
+	normalize := func(rules *entity.PolicyRules) error {
+		for i, region := range rules.AllowedRegions {
+			rules.AllowedRegions[i] = helper.NormalizeRegion(region)
+		}
+		for i, subscription := range rules.AllowedSubscriptions {
+			rules.AllowedSubscriptions[i] = strings.ToLower(subscription)
+		}
+		for i, pattern := range rules.AllowedResourceGroups {
+			rules.AllowedResourceGroups[i] = strings.ToLower(pattern)
+			if _, err := path.Match(rules.AllowedResourceGroups[i], ""); err != nil {
+				return fmt.Errorf("POLICY_FILE has an invalid resource group pattern %s", pattern)
+			}
+		}
+		if rules.MaxInactivityMinutes < 0 {
+			return fmt.Errorf("POLICY_FILE maxInactivityMinutes must not be negative")
+		}
+		return nil
+	}
+
+	if err := normalize(&policy.Rules); err != nil {
+		return nil, err
+	}
+	for i := range policy.Overrides {
+		for j, objectId := range policy.Overrides[i].ObjectIds {
+			policy.Overrides[i].ObjectIds[j] = strings.ToLower(objectId)
+		}
+		if err := normalize(&policy.Overrides[i].Rules); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
 // Helper function to retrieve the value and log it
 func getEnv(env string) string {
 	value := os.Getenv(env)
@@ -0,0 +1,21 @@
+package entity
+
+// AdminServerQuery filters the servers admins list, empty fields match every server.
+type AdminServerQuery struct {
+	Status      string
+	Region      string
+	IdleMinutes int // Servers idle for at least this many minutes.
+}
+
+// AdminService lets admins see and fix any server without the ownership check. Every
+// action is audited in the logs and, for actions on a server, in the server's history.
+type AdminService interface {
+	ListServers(caller Caller, query AdminServerQuery) ([]Server, error)
+	// GetServer returns the stored server with its live state from the compute backend.
+	GetServer(caller Caller, userPrincipalName string) (Server, error)
+	// DestroyServer destroys the server's container group like the user would.
+	DestroyServer(caller Caller, userPrincipalName string) (Server, error)
+	// ResetServer marks a stuck server as failed and frees its lock so that it can be
+	// deployed or destroyed again.
+	ResetServer(caller Caller, userPrincipalName string) (Server, error)
+}
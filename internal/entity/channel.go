@@ -0,0 +1,27 @@
+package entity
+
+import "errors"
+
+var ErrReleaseChannelNotFound = errors.New("release channel not found")
+
+// ReleaseChannel is a named actlabs image users can pick for their server. Users is only
+// filled in for admins.
+type ReleaseChannel struct {
+	Name  string   `json:"name"`
+	Image string   `json:"image"`
+	Users []string `json:"users,omitempty"`
+}
+
+type ReleaseChannelService interface {
+	ListReleaseChannels() ([]ReleaseChannel, error)
+	// ListReleaseChannelUsers lists the channels with the users whose server is on each.
+	ListReleaseChannelUsers(caller Caller) ([]ReleaseChannel, error)
+	SetReleaseChannelImage(caller Caller, name string, image string) (ReleaseChannel, error)
+}
+
+// ReleaseChannelRepository keeps the images admins set for the channels. Channels
+// without an image set use the one from config.
+type ReleaseChannelRepository interface {
+	GetReleaseChannelImages() (map[string]string, error)
+	SetReleaseChannelImage(name string, image string) error
+}
@@ -0,0 +1,103 @@
+package entity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Actions recorded in a server's event history.
+const (
+	EventActionTransition string = "transition"
+	EventActionActivity   string = "activity"
+	EventActionAdmin      string = "admin"
+	EventActionOnboarding string = "onboarding"
+)
+
+// Page sizes of GET /server/events.
+const (
+	DefaultEventPageSize int = 50
+	MaxEventPageSize     int = 200
+)
+
+var ErrForbidden = errors.New("insufficient permissions")
+
+// Caller is who made the request that caused an event. Background work like the reaper
+// uses a fixed principal id and leaves the rest empty.
+type Caller struct {
+	PrincipalId string       `json:"principalId"`
+	IP          string       `json:"ip"`
+	RequestId   string       `json:"requestId"`
+	Roles       []string     `json:"-"`
+	Groups      []string     `json:"-"`
+	Permissions []Permission `json:"-"` // Granted to the roles and groups, see PermissionsOf.
+}
+
+var ReaperCaller = Caller{PrincipalId: "reaper"}
+
+// Can reports whether the caller has the permission.
+func (c Caller) Can(permission Permission) bool {
+	for _, p := range c.Permissions {
+		if p == permission || p == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerEvent is one entry in a server's append-only history. Events are partitioned by
+// the user and the row key sorts the newest event first.
+type ServerEvent struct {
+	PartitionKey      string `json:"PartitionKey"`
+	RowKey            string `json:"RowKey"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	Action            string `json:"action"`
+	FromStatus        string `json:"fromStatus"`
+	ToStatus          string `json:"toStatus"`
+	Reason            string `json:"reason"`
+	CallerPrincipalId string `json:"callerPrincipalId"`
+	CallerIP          string `json:"callerIp"`
+	RequestId         string `json:"requestId"`
+	Time              string `json:"time"`
+}
+
+// NewServerEvent returns an event of the server's user happening now. The random suffix
+// keeps events of the same instant apart.
+func NewServerEvent(userPrincipalName string, action string, caller Caller) ServerEvent {
+	now := time.Now()
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return ServerEvent{
+		PartitionKey:      userPrincipalName,
+		RowKey:            fmt.Sprintf("%019d-%s", math.MaxInt64-now.UnixNano(), hex.EncodeToString(suffix)),
+		UserPrincipalName: userPrincipalName,
+		Action:            action,
+		CallerPrincipalId: caller.PrincipalId,
+		CallerIP:          caller.IP,
+		RequestId:         caller.RequestId,
+		Time:              now.Format(time.RFC3339),
+	}
+}
+
+// EventQuery selects a page of a user's events. PageToken is the NextPageToken of the
+// previous page.
+type EventQuery struct {
+	UserPrincipalName string
+	PageToken         string
+	Limit             int
+}
+
+type EventPage struct {
+	Events        []ServerEvent `json:"events"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+}
+
+type EventRepository interface {
+	AppendEvent(event ServerEvent) error
+	ListEvents(query EventQuery) (EventPage, error)
+}
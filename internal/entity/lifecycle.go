@@ -0,0 +1,57 @@
+package entity
+
+import "errors"
+
+// Lifecycle states of a server, kept in Server.Status.
+const (
+	ServerStatusProvisioning    string = "provisioning"
+	ServerStatusWaitingForReady string = "waiting-for-ready"
+	ServerStatusRunning         string = "running"
+	ServerStatusStopping        string = "stopping"
+	ServerStatusStopped         string = "stopped"
+	ServerStatusDestroying      string = "destroying"
+	ServerStatusDestroyed       string = "destroyed"
+	ServerStatusFailed          string = "failed"
+)
+
+var ErrInvalidTransition = errors.New("invalid server state transition")
+
+// serverTransitions lists the states each state may move to. Servers without a record,
+// or with a status written before the lifecycle existed, use the "" entry.
+var serverTransitions = map[string][]string{
+	"":                          {ServerStatusProvisioning, ServerStatusDestroying},
+	ServerStatusProvisioning:    {ServerStatusWaitingForReady, ServerStatusFailed},
+	ServerStatusWaitingForReady: {ServerStatusRunning, ServerStatusFailed},
+	ServerStatusRunning:         {ServerStatusProvisioning, ServerStatusStopping, ServerStatusDestroying, ServerStatusFailed},
+	ServerStatusStopping:        {ServerStatusStopped, ServerStatusFailed},
+	ServerStatusStopped:         {ServerStatusProvisioning, ServerStatusDestroying},
+	ServerStatusDestroying:      {ServerStatusDestroyed, ServerStatusFailed},
+	ServerStatusDestroyed:       {ServerStatusProvisioning, ServerStatusDestroying},
+	ServerStatusFailed:          {ServerStatusProvisioning, ServerStatusDestroying},
+}
+
+// IsServerStatus reports whether status is one of the lifecycle states.
+func IsServerStatus(status string) bool {
+	_, ok := serverTransitions[status]
+	return ok && status != ""
+}
+
+// IsTransientServerStatus reports whether the server is in the middle of an operation.
+func IsTransientServerStatus(status string) bool {
+	return status == ServerStatusProvisioning ||
+		status == ServerStatusWaitingForReady ||
+		status == ServerStatusStopping ||
+		status == ServerStatusDestroying
+}
+
+func CanTransition(from string, to string) bool {
+	if !IsServerStatus(from) {
+		from = ""
+	}
+	for _, allowed := range serverTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package entity
+
+import "errors"
+
+var ErrServerLocked = errors.New("another change to the server is in progress")
+
+// ServerLockedError carries the operation holding the server's lock, if the lock is held
+// by one. It matches ErrServerLocked with errors.Is.
+type ServerLockedError struct {
+	Operation *Operation
+}
+
+func (e *ServerLockedError) Error() string {
+	if e.Operation == nil {
+		return ErrServerLocked.Error()
+	}
+	return ErrServerLocked.Error() + ": " + e.Operation.Type + " operation " + e.Operation.Id
+}
+
+func (e *ServerLockedError) Unwrap() error {
+	return ErrServerLocked
+}
@@ -0,0 +1,25 @@
+package entity
+
+// Blob containers the actlabs server expects in the user's storage account.
+var OnboardingBlobContainers = []string{"tfstate", "labs"}
+
+type OnboardingService interface {
+	// Onboard queues the onboarding of the user's subscription. The steps are reported
+	// on the returned operation.
+	Onboard(server Server, caller Caller) (Operation, error)
+}
+
+// OnboardingRepository prepares and inspects the resources in the user's subscription that the server needs.
+type OnboardingRepository interface {
+	ResourceProviderRegistered(server Server, namespace string) (bool, error)
+	ResourceGroupExists(server Server) (bool, error)
+	BlobContainerExists(server Server, containerName string) (bool, error)
+
+	// EnsureResourceGroup creates the resource group unless it exists and reports whether it was created.
+	EnsureResourceGroup(server Server) (bool, error)
+	// GetStorageAccountName returns the name of the storage account in the resource group, empty if there is none.
+	GetStorageAccountName(server Server) (string, error)
+	CreateStorageAccount(server Server, storageAccountName string) error
+	// EnsureBlobContainer creates the container unless it exists and reports whether it was created.
+	EnsureBlobContainer(server Server, containerName string) (bool, error)
+}
@@ -0,0 +1,81 @@
+package entity
+
+import "errors"
+
+const (
+	OperationStatusPending   string = "pending"
+	OperationStatusRunning   string = "running"
+	OperationStatusSucceeded string = "succeeded"
+	OperationStatusFailed    string = "failed"
+)
+
+const (
+	OperationEventStepStarted        string = "step-started"
+	OperationEventStepProgress       string = "step-progress"
+	OperationEventStepSucceeded      string = "step-succeeded"
+	OperationEventStepFailed         string = "step-failed"
+	OperationEventOperationSucceeded string = "operation-succeeded"
+	OperationEventOperationFailed    string = "operation-failed"
+)
+
+var (
+	ErrOperationNotFound = errors.New("operation not found")
+	ErrTooManyOperations = errors.New("too many operations in progress, try again later")
+)
+
+type OperationStep struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt"`
+}
+
+type Operation struct {
+	Id                string          `json:"id"`
+	Type              string          `json:"type"`
+	Status            string          `json:"status"`
+	UserPrincipalId   string          `json:"userPrincipalId"`
+	UserPrincipalName string          `json:"userPrincipalName"`
+	Steps             []OperationStep `json:"steps"`
+	Server            Server          `json:"server"`
+	Error             string          `json:"error"`
+	CreatedAt         string          `json:"createdAt"`
+	UpdatedAt         string          `json:"updatedAt"`
+}
+
+// OperationEvent is a single lifecycle event of an operation, pushed to clients
+// that are streaming the operation's progress.
+type OperationEvent struct {
+	OperationId string `json:"operationId"`
+	Type        string `json:"type"`
+	Step        string `json:"step"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// IsTerminal reports whether no more events will follow this one.
+func (e OperationEvent) IsTerminal() bool {
+	return e.Type == OperationEventOperationSucceeded || e.Type == OperationEventOperationFailed
+}
+
+// IsTerminal reports whether the operation has finished.
+func (o Operation) IsTerminal() bool {
+	return o.Status == OperationStatusSucceeded || o.Status == OperationStatusFailed
+}
+
+type OperationService interface {
+	GetOperation(id string, userPrincipalId string) (Operation, error)
+	// StreamOperation returns the current state of the operation and a channel of events
+	// that follow it. The returned function must be called to stop the stream.
+	StreamOperation(id string, userPrincipalId string) (Operation, <-chan OperationEvent, func(), error)
+}
+
+type OperationRepository interface {
+	UpsertOperation(operation Operation) error
+	GetOperation(id string) (Operation, error)
+
+	PublishOperationEvent(event OperationEvent) error
+	SubscribeOperationEvents(id string) (<-chan OperationEvent, func(), error)
+}
@@ -0,0 +1,41 @@
+package entity
+
+import "strings"
+
+// Permission is what a caller may do beyond changing their own server, which needs none.
+type Permission string
+
+const (
+	PermissionAll           Permission = "*"
+	PermissionServersRead   Permission = "servers.read"   // List and inspect any server and its history.
+	PermissionServersWrite  Permission = "servers.write"  // Destroy and reset any server.
+	PermissionChannelsWrite Permission = "channels.write" // Change the image of a release channel.
+	PermissionUsageRead     Permission = "usage.read"     // Read the usage of all users.
+)
+
+var Permissions = []Permission{
+	PermissionAll,
+	PermissionServersRead,
+	PermissionServersWrite,
+	PermissionChannelsWrite,
+	PermissionUsageRead,
+}
+
+func IsPermission(permission Permission) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsOf returns the permissions granted to the roles and groups. Grants are keyed
+// by the lowercased app role or group object id.
+func PermissionsOf(grants map[string][]Permission, roles []string, groups []string) []Permission {
+	permissions := []Permission{}
+	for _, name := range append(append([]string{}, roles...), groups...) {
+		permissions = append(permissions, grants[strings.ToLower(name)]...)
+	}
+	return permissions
+}
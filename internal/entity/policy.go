@@ -0,0 +1,60 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrPolicyViolation = errors.New("policy violation")
+
+// Names of the policy rules, as they appear in the policy file and in violations.
+const (
+	PolicyRuleAllowedRegions        string = "allowedRegions"
+	PolicyRuleAllowedResourceGroups string = "allowedResourceGroups"
+	PolicyRuleAllowedImageChannels  string = "allowedImageChannels"
+	PolicyRuleAllowedSizes          string = "allowedSizes"
+	PolicyRuleAllowedLogLevels      string = "allowedLogLevels"
+	PolicyRuleAllowedSubscriptions  string = "allowedSubscriptions"
+	PolicyRuleMaxInactivityMinutes  string = "maxInactivityMinutes"
+)
+
+// PolicyRules restrict the settings of servers. Rules that are not set allow everything.
+// Resource groups are matched as patterns, e.g. "repro-*", ignoring case.
+type PolicyRules struct {
+	AllowedRegions        []string `json:"allowedRegions,omitempty"`
+	AllowedResourceGroups []string `json:"allowedResourceGroups,omitempty"`
+	AllowedImageChannels  []string `json:"allowedImageChannels,omitempty"`
+	AllowedSizes          []string `json:"allowedSizes,omitempty"`
+	AllowedLogLevels      []string `json:"allowedLogLevels,omitempty"`
+	AllowedSubscriptions  []string `json:"allowedSubscriptions,omitempty"`
+	MaxInactivityMinutes  int      `json:"maxInactivityMinutes,omitempty"`
+}
+
+// PolicyOverride replaces the rules it sets for the users and groups with the object ids.
+type PolicyOverride struct {
+	ObjectIds []string    `json:"objectIds"`
+	Rules     PolicyRules `json:"rules"`
+}
+
+// Policy is the policy file. Overrides that apply to the caller are applied in order, so
+// a later override wins over an earlier one.
+type Policy struct {
+	Rules     PolicyRules      `json:"rules"`
+	Overrides []PolicyOverride `json:"overrides"`
+}
+
+// PolicyViolationError names the rule a server broke.
+type PolicyViolationError struct {
+	Rule    string   `json:"rule"`
+	Value   string   `json:"value"`
+	Allowed []string `json:"allowed"`
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("%s: %s does not allow %s, allowed are %s", ErrPolicyViolation, e.Rule, e.Value, strings.Join(e.Allowed, ", "))
+}
+
+func (e *PolicyViolationError) Unwrap() error {
+	return ErrPolicyViolation
+}
@@ -0,0 +1,40 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrPreflightFailed = errors.New("preflight checks failed")
+
+// PreflightCheck is one prerequisite of a server in the user's subscription.
+type PreflightCheck struct {
+	Name        string `json:"name"`
+	Passed      bool   `json:"passed"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+type PreflightResult struct {
+	Passed bool             `json:"passed"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// PreflightError carries the failed checklist, it matches ErrPreflightFailed with errors.Is.
+type PreflightError struct {
+	Result PreflightResult
+}
+
+func (e *PreflightError) Error() string {
+	failed := []string{}
+	for _, check := range e.Result.Checks {
+		if !check.Passed {
+			failed = append(failed, check.Name+": "+check.Message)
+		}
+	}
+	return ErrPreflightFailed.Error() + ": " + strings.Join(failed, "; ")
+}
+
+func (e *PreflightError) Unwrap() error {
+	return ErrPreflightFailed
+}
@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+// ReapDecision records why the reaper did or did not act on a server.
+type ReapDecision struct {
+	UserPrincipalName   string `json:"userPrincipalName"`
+	UserAlias           string `json:"userAlias"`
+	SubscriptionId      string `json:"subscriptionId"`
+	Action              string `json:"action"` // "reap", "skip" or "error"
+	IdleAction          string `json:"idleAction"`
+	Reason              string `json:"reason"`
+	IdleMinutes         int    `json:"idleMinutes"`
+	InactivityThreshold int    `json:"inactivityThreshold"`
+	DryRun              bool   `json:"dryRun"`
+}
+
+type ReaperService interface {
+	// Start runs the reaper on the configured interval until the context is cancelled.
+	Start(ctx context.Context)
+	// Reap performs a single sweep over all servers in the database.
+	Reap() ([]ReapDecision, error)
+}
+
+// Locker is a distributed lock used to coordinate work across manager replicas.
+type Locker interface {
+	Acquire(key string, owner string, ttl time.Duration) (bool, error)
+	Release(key string, owner string) error
+	// Renew extends the lock if the owner still holds it.
+	Renew(key string, owner string, ttl time.Duration) (bool, error)
+	// Holder returns the owner of the lock, empty if nobody holds it.
+	Holder(key string) (string, error)
+}
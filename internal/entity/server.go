@@ -1,12 +1,45 @@
 package entity
 
+import (
+	"context"
+	"errors"
+)
+
+const (
+	IdleActionDestroy string = "destroy"
+	IdleActionStop    string = "stop"
+)
+
+// Containers in the server's container group, used to pick the container to read logs from.
+var ServerContainerNames = []string{"actlabs", "caddy", "init"}
+
+var (
+	ErrInvalidLogsRequest = errors.New("invalid logs request")
+	ErrNotSupported       = errors.New("not supported by the server's compute backend")
+	ErrServerNotFound     = errors.New("server not found")
+	ErrRegionNotAllowed   = errors.New("region not allowed")
+	ErrInvalidName        = errors.New("invalid resource group or user alias")
+	// ErrPrincipalNotFound is returned while a new managed identity hasn't reached the directory yet.
+	ErrPrincipalNotFound = errors.New("principal not found in the directory")
+)
+
+// Compute backends a server can run on.
+const (
+	BackendAzureContainerInstances string = "aci"
+	BackendAzureContainerApps      string = "containerapps"
+	BackendDocker                  string = "docker"
+)
+
 const OwnerRoleDefinitionId string = "/subscriptions/da846304-0089-48e0-bfa7-65f68a3eb74f/providers/Microsoft.Authorization/roleDefinitions/8e3af657-a8ff-443c-a75c-2fe8c4bcb635"
 
 type Server struct {
 	PartitionKey                string `json:"PartitionKey"`
 	RowKey                      string `json:"RowKey"`
 	Endpoint                    string `json:"endpoint"`
-	Status                      string `json:"status"`
+	Status                      string `json:"status"`            // Lifecycle state, one of the ServerStatus constants.
+	StatusReason                string `json:"statusReason"`      // Why the server moved to its status.
+	StatusTime                  string `json:"statusTime"`        // When the server moved to its status.
+	ProvisioningState           string `json:"provisioningState"` // State reported by the compute backend.
 	Region                      string `json:"region"`
 	UserPrincipalId             string `json:"userPrincipalId"`
 	UserPrincipalName           string `json:"userPrincipalName"`
@@ -21,16 +54,61 @@ type Server struct {
 	AutoCreate                  bool   `json:"autoCreate"`
 	AutoDestroy                 bool   `json:"autoDestroy"`
 	InactivityDurationInMinutes int    `json:"inactivityDurationInMinutes"`
+	IdleAction                  string `json:"idleAction"` // What to do when the server is idle and AutoDestroy is set, "destroy" or "stop".
+	Backend                     string `json:"backend"`
+	StorageAccountName          string `json:"storageAccountName"`
+	ImageChannel                string `json:"imageChannel"` // Release channel the user picked, one of the configured channels.
+	Image                       string `json:"image"`        // Actlabs image of the channel when the server was deployed.
+	Size                        string `json:"size"`         // Name of the configured size of the actlabs container.
 }
 
+// RoleAssignment of the server's managed identity. RoleDefinitionId is the role's guid.
+type RoleAssignment struct {
+	Id               string `json:"id"`
+	RoleDefinitionId string `json:"roleDefinitionId"`
+	Scope            string `json:"scope"`
+}
+
+// ServerService methods that change a server take the caller, who is recorded in the
+// server's event history.
 type ServerService interface {
-	DeployServer(server Server) (Server, error)
-	DestroyServer(server Server) error
-	GetServer(server Server) (Server, error)
+	DeployServer(server Server, caller Caller) (Operation, error)
+	DestroyServer(server Server, caller Caller) error
+	// TeardownServer removes everything the manager created for the user: the container
+	// group, the managed identity with its role assignments and the server record.
+	TeardownServer(server Server, caller Caller) (Operation, error)
+	StopServer(server Server, caller Caller) (Server, error)
+	StartServer(server Server, caller Caller) (Operation, error)
+	RestartServer(server Server, caller Caller) (Operation, error)
+	GetServer(server Server, caller Caller) (Server, error)
+	// Preflight checks the prerequisites of the server in the user's subscription.
+	Preflight(server Server, caller Caller) (PreflightResult, error)
+	GetServerLogs(server Server, caller Caller, containerName string, tail int) (string, error)
+	// FollowServerLogs streams new log lines until the context is cancelled.
+	FollowServerLogs(ctx context.Context, server Server, caller Caller, containerName string, tail int) (<-chan string, error)
+	ListServerEvents(server Server, caller Caller, query EventQuery) (EventPage, error)
+	// ListServerSizes returns the sizes and the largest one the caller may deploy.
+	ListServerSizes(caller Caller) ServerSizes
 
-	UpdateActivityStatus(userPrincipalName string) error
+	UpdateActivityStatus(userPrincipalName string, caller Caller) error
 }
 
+// ComputeBackend runs servers on a particular compute platform.
+type ComputeBackend interface {
+	Get(server Server) (Server, error)
+	Deploy(server Server) (Server, error)
+	Destroy(server Server) error
+	Stop(server Server) error
+	Start(server Server) error
+	Restart(server Server) error
+
+	EnsureUp(server Server) error
+
+	GetLogs(server Server, containerName string, tail int, timestamps bool) (string, error)
+	ExecuteCommand(server Server, request TerminalRequest) (ContainerExec, error)
+}
+
+// ServerRepository routes the container group operations to the server's ComputeBackend.
 type ServerRepository interface {
 	GetAzureContainerGroup(server Server) (Server, error)
 	GetUserAssignedManagedIdentity(server Server) (Server, error)
@@ -39,11 +117,40 @@ type ServerRepository interface {
 	CreateUserAssignedManagedIdentity(server Server) (Server, error)
 
 	EnsureServerUp(server Server) error
+	GetContainerLogs(server Server, containerName string, tail int, timestamps bool) (string, error)
+	ExecuteContainerCommand(server Server, request TerminalRequest) (ContainerExec, error)
 
 	DestroyAzureContainerGroup(server Server) error
+	StopAzureContainerGroup(server Server) error
+	StartAzureContainerGroup(server Server) error
+	RestartAzureContainerGroup(server Server) error
+
+	// Role assignments of the managed identity on the server's subscription.
+	ListManagedIdentityRoleAssignments(server Server) ([]RoleAssignment, error)
+	CreateManagedIdentityRoleAssignment(server Server, roleDefinitionId string) error
+	DeleteManagedIdentityRoleAssignment(server Server, roleAssignmentId string) error
+
+	DeleteUserAssignedManagedIdentity(server Server) error
 
 	IsUserOwner(server Server) (bool, error)
 
 	UpsertServerInDatabase(server Server) error
 	GetServerFromDatabase(partitionKey string, rowKey string) (Server, error)
+	ListServersFromDatabase(query ServerQuery) ([]Server, error)
+	DeleteServerFromDatabase(partitionKey string, rowKey string) error
+}
+
+// ServerQuery filters the servers listed from the store, empty fields match every server.
+type ServerQuery struct {
+	SubscriptionId string
+	Status         string
+	Backend        string
+}
+
+// ServerStore persists server records. GetServer returns ErrServerNotFound when there is no record.
+type ServerStore interface {
+	UpsertServer(server Server) error
+	GetServer(partitionKey string, rowKey string) (Server, error)
+	ListServers(query ServerQuery) ([]Server, error)
+	DeleteServer(partitionKey string, rowKey string) error
 }
@@ -0,0 +1,21 @@
+package entity
+
+import "errors"
+
+var (
+	ErrServerSizeNotFound   = errors.New("server size not found")
+	ErrServerSizeNotAllowed = errors.New("server size not allowed")
+)
+
+// ServerSize is a named profile of the CPU cores and memory in GB of the actlabs container.
+type ServerSize struct {
+	Name   string  `json:"name"`
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+}
+
+// ServerSizes are the configured sizes, smallest first, with the largest the caller may pick.
+type ServerSizes struct {
+	Sizes   []ServerSize `json:"sizes"`
+	MaxSize string       `json:"maxSize"`
+}
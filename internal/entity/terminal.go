@@ -0,0 +1,46 @@
+package entity
+
+import "errors"
+
+// Commands a terminal session is allowed to start.
+var TerminalCommands = []string{"/bin/bash", "/bin/sh"}
+
+var ErrInvalidTerminalRequest = errors.New("invalid terminal request")
+
+// ContainerExec holds what is needed to connect to an exec session in a container.
+type ContainerExec struct {
+	WebSocketUri string `json:"webSocketUri"`
+	Password     string `json:"-"`
+}
+
+type TerminalRequest struct {
+	ContainerName string `json:"containerName"`
+	Command       string `json:"command"`
+	Rows          int    `json:"rows"`
+	Cols          int    `json:"cols"`
+}
+
+// TerminalSession is the audit record of an interactive session in a user's container.
+type TerminalSession struct {
+	Id                string `json:"id"`
+	UserPrincipalId   string `json:"userPrincipalId"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	SubscriptionId    string `json:"subscriptionId"`
+	ContainerName     string `json:"containerName"`
+	Command           string `json:"command"`
+	ClientIP          string `json:"clientIp"`
+	StartedAt         string `json:"startedAt"`
+	EndedAt           string `json:"endedAt"`
+	EndReason         string `json:"endReason"`
+}
+
+type TerminalService interface {
+	// StartSession validates the request, opens an exec session in the container and records
+	// the start of the session.
+	StartSession(server Server, request TerminalRequest, caller Caller) (TerminalSession, ContainerExec, error)
+	EndSession(session TerminalSession, reason string)
+}
+
+type TerminalRepository interface {
+	RecordTerminalSession(session TerminalSession) error
+}
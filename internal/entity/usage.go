@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+// Periods usage is reported by.
+const (
+	UsagePeriodDaily   string = "daily"
+	UsagePeriodMonthly string = "monthly"
+)
+
+// UsageTimeFormat is the format of the times of usage intervals. The times are in UTC so
+// that they sort and compare as strings.
+const UsageTimeFormat = "2006-01-02T15:04:05Z"
+
+var ErrInvalidUsageRequest = errors.New("invalid usage request")
+
+// UsagePrice is what an hour of a CPU core and of a GB of memory costs on a compute backend.
+type UsagePrice struct {
+	CPUHour      float64 `json:"cpuHour"`
+	MemoryGBHour float64 `json:"memoryGbHour"`
+}
+
+// UsageInterval is a stretch of time a server was running with the resources it had.
+// End is empty while the server is running.
+type UsageInterval struct {
+	PartitionKey      string  `json:"PartitionKey"`
+	RowKey            string  `json:"RowKey"`
+	UserPrincipalName string  `json:"userPrincipalName"`
+	Backend           string  `json:"backend"`
+	Size              string  `json:"size"`
+	CPU               float64 `json:"cpu"`
+	Memory            float64 `json:"memory"`
+	Start             string  `json:"start"`
+	End               string  `json:"end"`
+}
+
+// NewUsageInterval starts an interval of the user's server at the given time. Row keys
+// order the intervals of a user by when they started.
+func NewUsageInterval(userPrincipalName string, start time.Time) UsageInterval {
+	return UsageInterval{
+		PartitionKey:      userPrincipalName,
+		RowKey:            start.UTC().Format("20060102T150405.000000000Z"),
+		UserPrincipalName: userPrincipalName,
+		Start:             start.UTC().Format(UsageTimeFormat),
+	}
+}
+
+// UsageQuery selects the intervals that overlap [From, To), or only the ones still open.
+// Intervals of every user are selected when UserPrincipalName is empty.
+type UsageQuery struct {
+	UserPrincipalName string
+	From              time.Time
+	To                time.Time
+	OpenOnly          bool
+}
+
+// UsageBucket is the usage of a user in one day or month.
+type UsageBucket struct {
+	UserPrincipalName string  `json:"userPrincipalName"`
+	Period            string  `json:"period"`
+	Hours             float64 `json:"hours"`
+	CPUHours          float64 `json:"cpuHours"`
+	MemoryGBHours     float64 `json:"memoryGbHours"`
+	Cost              float64 `json:"cost"`
+}
+
+type UsageReport struct {
+	Period    string        `json:"period"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Currency  string        `json:"currency"`
+	Buckets   []UsageBucket `json:"buckets"`
+	TotalCost float64       `json:"totalCost"`
+}
+
+type UsageService interface {
+	// GetUsage reports the usage of the server's user between the dates, yyyy-mm-dd, to exclusive.
+	GetUsage(server Server, caller Caller, period string, from string, to string) (UsageReport, error)
+	// GetUsageReport reports the usage of every user, for admins.
+	GetUsageReport(caller Caller, period string, from string, to string) (UsageReport, error)
+}
+
+type UsageRepository interface {
+	UpsertUsageInterval(interval UsageInterval) error
+	ListUsageIntervals(query UsageQuery) ([]UsageInterval, error)
+}
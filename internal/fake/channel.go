@@ -0,0 +1,39 @@
+package fake
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"sync"
+)
+
+var _ entity.ReleaseChannelRepository = (*ReleaseChannelRepository)(nil)
+
+// ReleaseChannelRepository is an in-memory entity.ReleaseChannelRepository.
+type ReleaseChannelRepository struct {
+	mu     sync.Mutex
+	images map[string]string
+}
+
+func NewReleaseChannelRepository() *ReleaseChannelRepository {
+	return &ReleaseChannelRepository{
+		images: map[string]string{},
+	}
+}
+
+func (f *ReleaseChannelRepository) GetReleaseChannelImages() (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	images := map[string]string{}
+	for name, image := range f.images {
+		images[name] = image
+	}
+	return images, nil
+}
+
+func (f *ReleaseChannelRepository) SetReleaseChannelImage(name string, image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.images[name] = image
+	return nil
+}
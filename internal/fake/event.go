@@ -0,0 +1,57 @@
+package fake
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"sort"
+	"sync"
+)
+
+var _ entity.EventRepository = (*EventRepository)(nil)
+
+// EventRepository is an in-memory entity.EventRepository that pages like the real stores.
+type EventRepository struct {
+	mu     sync.Mutex
+	events map[string][]entity.ServerEvent
+}
+
+func NewEventRepository() *EventRepository {
+	return &EventRepository{
+		events: map[string][]entity.ServerEvent{},
+	}
+}
+
+// Events returns every event of the user, newest first.
+func (f *EventRepository) Events(userPrincipalName string) []entity.ServerEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]entity.ServerEvent{}, f.events[userPrincipalName]...)
+}
+
+func (f *EventRepository) AppendEvent(event entity.ServerEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := append(f.events[event.PartitionKey], event)
+	sort.Slice(events, func(i, j int) bool { return events[i].RowKey < events[j].RowKey })
+	f.events[event.PartitionKey] = events
+	return nil
+}
+
+func (f *EventRepository) ListEvents(query entity.EventQuery) (entity.EventPage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	page := entity.EventPage{Events: []entity.ServerEvent{}}
+	for _, event := range f.events[query.UserPrincipalName] {
+		if event.RowKey <= query.PageToken {
+			continue
+		}
+		if len(page.Events) == query.Limit {
+			page.NextPageToken = page.Events[len(page.Events)-1].RowKey
+			break
+		}
+		page.Events = append(page.Events, event)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,77 @@
+package fake
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"sync"
+	"time"
+)
+
+var _ entity.Locker = (*Locker)(nil)
+
+// Locker is an in-memory entity.Locker with expiring locks like the redis one.
+type Locker struct {
+	mu    sync.Mutex
+	locks map[string]lock
+}
+
+type lock struct {
+	owner   string
+	expires time.Time
+}
+
+func NewLocker() *Locker {
+	return &Locker{
+		locks: map[string]lock{},
+	}
+}
+
+// held returns the lock of the key if it has not expired. The caller holds mu.
+func (f *Locker) held(key string) (lock, bool) {
+	l, ok := f.locks[key]
+	if !ok || time.Now().After(l.expires) {
+		return lock{}, false
+	}
+	return l, true
+}
+
+func (f *Locker) Acquire(key string, owner string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.held(key); ok {
+		return false, nil
+	}
+	f.locks[key] = lock{owner: owner, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (f *Locker) Release(key string, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if l, ok := f.held(key); ok && l.owner == owner {
+		delete(f.locks, key)
+	}
+	return nil
+}
+
+func (f *Locker) Renew(key string, owner string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, ok := f.held(key)
+	if !ok || l.owner != owner {
+		return false, nil
+	}
+	l.expires = time.Now().Add(ttl)
+	f.locks[key] = l
+	return true, nil
+}
+
+func (f *Locker) Holder(key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, _ := f.held(key)
+	return l.owner, nil
+}
@@ -0,0 +1,96 @@
+package fake
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"sync"
+)
+
+var _ entity.OperationRepository = (*OperationRepository)(nil)
+
+// OperationRepository is an in-memory entity.OperationRepository. Events are delivered
+// to the subscribers of the operation the same way redis pub/sub does, events published
+// while nobody is subscribed are only kept for Events.
+type OperationRepository struct {
+	mu          sync.Mutex
+	operations  map[string]entity.Operation
+	events      map[string][]entity.OperationEvent
+	subscribers map[string][]chan entity.OperationEvent
+}
+
+func NewOperationRepository() *OperationRepository {
+	return &OperationRepository{
+		operations:  map[string]entity.Operation{},
+		events:      map[string][]entity.OperationEvent{},
+		subscribers: map[string][]chan entity.OperationEvent{},
+	}
+}
+
+// Events returns every event published for the operation so far.
+func (f *OperationRepository) Events(id string) []entity.OperationEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]entity.OperationEvent{}, f.events[id]...)
+}
+
+func (f *OperationRepository) UpsertOperation(operation entity.Operation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Steps are copied so that later changes by the caller don't leak into the store.
+	operation.Steps = append([]entity.OperationStep{}, operation.Steps...)
+	f.operations[operation.Id] = operation
+	return nil
+}
+
+func (f *OperationRepository) GetOperation(id string) (entity.Operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	operation, ok := f.operations[id]
+	if !ok {
+		return entity.Operation{}, entity.ErrOperationNotFound
+	}
+	return operation, nil
+}
+
+func (f *OperationRepository) PublishOperationEvent(event entity.OperationEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events[event.OperationId] = append(f.events[event.OperationId], event)
+	for _, subscriber := range f.subscribers[event.OperationId] {
+		// Like pub/sub, slow subscribers miss events rather than block the publisher.
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (f *OperationRepository) SubscribeOperationEvents(id string) (<-chan entity.OperationEvent, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := make(chan entity.OperationEvent, 100)
+	f.subscribers[id] = append(f.subscribers[id], events)
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			subscribers := f.subscribers[id]
+			for i, subscriber := range subscribers {
+				if subscriber == events {
+					f.subscribers[id] = append(subscribers[:i], subscribers[i+1:]...)
+					break
+				}
+			}
+			close(events)
+		})
+	}
+
+	return events, unsubscribe, nil
+}
@@ -0,0 +1,392 @@
+// Package fake provides in-memory implementations of the repositories so that services
+// and handlers can be exercised without Azure or redis.
+package fake
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for container groups that don't exist.
+var ErrNotFound = errors.New("not found")
+
+var _ entity.ServerRepository = (*ServerRepository)(nil)
+
+// ServerRepository is an in-memory entity.ServerRepository. Container groups are keyed by
+// user alias and server records by partition and row key, like the servers table.
+//
+// Every method can be made to fail with FailOn, and calls that would reach Azure take
+// the configured latency.
+type ServerRepository struct {
+	mu sync.Mutex
+
+	latency    time.Duration
+	owner      bool
+	ownerErr   error
+	readyAfter int
+	readyCalls int
+	errs       map[string]error
+	calls      []string
+
+	groups  map[string]entity.Server
+	servers map[string]entity.Server
+	roles   map[string][]entity.RoleAssignment
+}
+
+// NewServerRepository returns a repository in which every user owns their subscription
+// and servers are up as soon as they are deployed.
+func NewServerRepository() *ServerRepository {
+	return &ServerRepository{
+		owner:   true,
+		errs:    map[string]error{},
+		groups:  map[string]entity.Server{},
+		servers: map[string]entity.Server{},
+		roles:   map[string][]entity.RoleAssignment{},
+	}
+}
+
+// FailOn makes the named method, e.g. "DeployAzureContainerGroup", return err.
+// A nil err clears the failure.
+func (f *ServerRepository) FailOn(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+// SetLatency delays every call that would go to Azure.
+func (f *ServerRepository) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = latency
+}
+
+// SetOwner sets the answer of IsUserOwner.
+func (f *ServerRepository) SetOwner(owner bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.owner = owner
+	f.ownerErr = err
+}
+
+// SetReadyAfter makes EnsureServerUp fail the given number of times before the server
+// is up. A negative number keeps the server down for good.
+func (f *ServerRepository) SetReadyAfter(attempts int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readyAfter = attempts
+	f.readyCalls = 0
+}
+
+// Calls returns the names of the methods called so far, in order.
+func (f *ServerRepository) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+// CallCount returns how many times the named method was called.
+func (f *ServerRepository) CallCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+	for _, call := range f.calls {
+		if call == method {
+			count++
+		}
+	}
+	return count
+}
+
+// ContainerGroup returns the deployed container group of the user, if any.
+func (f *ServerRepository) ContainerGroup(userAlias string) (entity.Server, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.groups[userAlias]
+	return server, ok
+}
+
+// call records the call and returns the programmed failure. The lock is held on return.
+func (f *ServerRepository) call(method string, remote bool) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, method)
+	latency := f.latency
+	f.mu.Unlock()
+
+	if remote && latency > 0 {
+		time.Sleep(latency)
+	}
+
+	f.mu.Lock()
+	return f.errs[method]
+}
+
+func (f *ServerRepository) GetAzureContainerGroup(server entity.Server) (entity.Server, error) {
+	err := f.call("GetAzureContainerGroup", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return server, err
+	}
+
+	group, ok := f.groups[server.UserAlias]
+	if !ok {
+		return server, fmt.Errorf("container group %s-aci: %w", server.UserAlias, ErrNotFound)
+	}
+
+	server.Endpoint = group.Endpoint
+	server.ProvisioningState = group.ProvisioningState
+	return server, nil
+}
+
+func (f *ServerRepository) GetUserAssignedManagedIdentity(server entity.Server) (entity.Server, error) {
+	err := f.call("GetUserAssignedManagedIdentity", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return server, err
+	}
+	return withIdentity(server), nil
+}
+
+func (f *ServerRepository) DeployAzureContainerGroup(server entity.Server) (entity.Server, error) {
+	err := f.call("DeployAzureContainerGroup", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return server, err
+	}
+
+	server.Endpoint = server.UserAlias + ".fake"
+	server.ProvisioningState = "Succeeded"
+	f.groups[server.UserAlias] = server
+	f.readyCalls = 0
+
+	return server, nil
+}
+
+func (f *ServerRepository) CreateUserAssignedManagedIdentity(server entity.Server) (entity.Server, error) {
+	err := f.call("CreateUserAssignedManagedIdentity", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return server, err
+	}
+	return withIdentity(server), nil
+}
+
+func (f *ServerRepository) EnsureServerUp(server entity.Server) error {
+	err := f.call("EnsureServerUp", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	group, ok := f.groups[server.UserAlias]
+	if !ok || group.ProvisioningState != "Succeeded" {
+		return errors.New("server is not up")
+	}
+
+	f.readyCalls++
+	if f.readyAfter < 0 || f.readyCalls <= f.readyAfter {
+		return errors.New("server is not up")
+	}
+
+	return nil
+}
+
+func (f *ServerRepository) GetContainerLogs(server entity.Server, containerName string, tail int, timestamps bool) (string, error) {
+	err := f.call("GetContainerLogs", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := f.groups[server.UserAlias]; !ok {
+		return "", fmt.Errorf("container group %s-aci: %w", server.UserAlias, ErrNotFound)
+	}
+
+	return "logs of " + containerName + "\n", nil
+}
+
+func (f *ServerRepository) ExecuteContainerCommand(server entity.Server, request entity.TerminalRequest) (entity.ContainerExec, error) {
+	err := f.call("ExecuteContainerCommand", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return entity.ContainerExec{}, err
+	}
+	return entity.ContainerExec{}, entity.ErrNotSupported
+}
+
+func (f *ServerRepository) DestroyAzureContainerGroup(server entity.Server) error {
+	err := f.call("DestroyAzureContainerGroup", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Deleting a container group that doesn't exist succeeds in Azure too.
+	delete(f.groups, server.UserAlias)
+	return nil
+}
+
+func (f *ServerRepository) StopAzureContainerGroup(server entity.Server) error {
+	return f.setGroupState("StopAzureContainerGroup", server, "Stopped")
+}
+
+func (f *ServerRepository) StartAzureContainerGroup(server entity.Server) error {
+	return f.setGroupState("StartAzureContainerGroup", server, "Succeeded")
+}
+
+func (f *ServerRepository) RestartAzureContainerGroup(server entity.Server) error {
+	return f.setGroupState("RestartAzureContainerGroup", server, "Succeeded")
+}
+
+func (f *ServerRepository) setGroupState(method string, server entity.Server, state string) error {
+	err := f.call(method, true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	group, ok := f.groups[server.UserAlias]
+	if !ok {
+		return fmt.Errorf("container group %s-aci: %w", server.UserAlias, ErrNotFound)
+	}
+
+	group.ProvisioningState = state
+	f.groups[server.UserAlias] = group
+	f.readyCalls = 0
+	return nil
+}
+
+// Role assignments are keyed by the identity's principal id.
+func (f *ServerRepository) ListManagedIdentityRoleAssignments(server entity.Server) ([]entity.RoleAssignment, error) {
+	err := f.call("ListManagedIdentityRoleAssignments", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return append([]entity.RoleAssignment{}, f.roles[server.ManagedIdentityPrincipalId]...), nil
+}
+
+func (f *ServerRepository) CreateManagedIdentityRoleAssignment(server entity.Server, roleDefinitionId string) error {
+	err := f.call("CreateManagedIdentityRoleAssignment", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, roleAssignment := range f.roles[server.ManagedIdentityPrincipalId] {
+		if roleAssignment.RoleDefinitionId == roleDefinitionId {
+			return nil
+		}
+	}
+
+	f.roles[server.ManagedIdentityPrincipalId] = append(f.roles[server.ManagedIdentityPrincipalId], entity.RoleAssignment{
+		Id:               fmt.Sprintf("%s-%s", server.ManagedIdentityPrincipalId, roleDefinitionId),
+		RoleDefinitionId: roleDefinitionId,
+		Scope:            "/subscriptions/" + server.SubscriptionId,
+	})
+	return nil
+}
+
+func (f *ServerRepository) DeleteManagedIdentityRoleAssignment(server entity.Server, roleAssignmentId string) error {
+	err := f.call("DeleteManagedIdentityRoleAssignment", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	roleAssignments := []entity.RoleAssignment{}
+	for _, roleAssignment := range f.roles[server.ManagedIdentityPrincipalId] {
+		if roleAssignment.Id != roleAssignmentId {
+			roleAssignments = append(roleAssignments, roleAssignment)
+		}
+	}
+	f.roles[server.ManagedIdentityPrincipalId] = roleAssignments
+	return nil
+}
+
+func (f *ServerRepository) DeleteUserAssignedManagedIdentity(server entity.Server) error {
+	err := f.call("DeleteUserAssignedManagedIdentity", true)
+	defer f.mu.Unlock()
+	return err
+}
+
+func (f *ServerRepository) IsUserOwner(server entity.Server) (bool, error) {
+	err := f.call("IsUserOwner", true)
+	defer f.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return f.owner, f.ownerErr
+}
+
+func (f *ServerRepository) UpsertServerInDatabase(server entity.Server) error {
+	err := f.call("UpsertServerInDatabase", false)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	server.PartitionKey = "actlabs"
+	server.RowKey = server.UserPrincipalName
+	f.servers[server.PartitionKey+"/"+server.RowKey] = server
+	return nil
+}
+
+func (f *ServerRepository) GetServerFromDatabase(partitionKey string, rowKey string) (entity.Server, error) {
+	err := f.call("GetServerFromDatabase", false)
+	defer f.mu.Unlock()
+	if err != nil {
+		return entity.Server{}, err
+	}
+
+	server, ok := f.servers[partitionKey+"/"+rowKey]
+	if !ok {
+		return entity.Server{}, entity.ErrServerNotFound
+	}
+	return server, nil
+}
+
+func (f *ServerRepository) ListServersFromDatabase(query entity.ServerQuery) ([]entity.Server, error) {
+	err := f.call("ListServersFromDatabase", false)
+	defer f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []entity.Server{}
+	for _, server := range f.servers {
+		if (query.SubscriptionId == "" || server.SubscriptionId == query.SubscriptionId) &&
+			(query.Status == "" || server.Status == query.Status) &&
+			(query.Backend == "" || server.Backend == query.Backend) {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+func (f *ServerRepository) DeleteServerFromDatabase(partitionKey string, rowKey string) error {
+	err := f.call("DeleteServerFromDatabase", false)
+	defer f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	delete(f.servers, partitionKey+"/"+rowKey)
+	return nil
+}
+
+func withIdentity(server entity.Server) entity.Server {
+	server.ManagedIdentityResourceId = "/subscriptions/" + server.SubscriptionId + "/resourceGroups/" + server.ResourceGroup +
+		"/providers/Microsoft.ManagedIdentity/userAssignedIdentities/" + server.UserAlias + "-msi"
+	server.ManagedIdentityClientId = server.UserAlias + "-client-id"
+	server.ManagedIdentityPrincipalId = server.UserAlias + "-principal-id"
+	return server
+}
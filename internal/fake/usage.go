@@ -0,0 +1,60 @@
+package fake
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"sort"
+	"sync"
+)
+
+var _ entity.UsageRepository = (*UsageRepository)(nil)
+
+// UsageRepository is an in-memory entity.UsageRepository that selects like the real stores.
+type UsageRepository struct {
+	mu        sync.Mutex
+	intervals map[string]entity.UsageInterval
+}
+
+func NewUsageRepository() *UsageRepository {
+	return &UsageRepository{
+		intervals: map[string]entity.UsageInterval{},
+	}
+}
+
+func (f *UsageRepository) UpsertUsageInterval(interval entity.UsageInterval) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.intervals[interval.PartitionKey+"/"+interval.RowKey] = interval
+	return nil
+}
+
+func (f *UsageRepository) ListUsageIntervals(query entity.UsageQuery) ([]entity.UsageInterval, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	from := query.From.UTC().Format(entity.UsageTimeFormat)
+	to := query.To.UTC().Format(entity.UsageTimeFormat)
+
+	intervals := []entity.UsageInterval{}
+	for _, interval := range f.intervals {
+		if query.UserPrincipalName != "" && interval.PartitionKey != query.UserPrincipalName {
+			continue
+		}
+		if query.OpenOnly && interval.End != "" {
+			continue
+		}
+		if !query.OpenOnly && (interval.Start >= to || (interval.End != "" && interval.End <= from)) {
+			continue
+		}
+		intervals = append(intervals, interval)
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		if intervals[i].PartitionKey != intervals[j].PartitionKey {
+			return intervals[i].PartitionKey < intervals[j].PartitionKey
+		}
+		return intervals[i].RowKey < intervals[j].RowKey
+	})
+
+	return intervals, nil
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type adminHandler struct {
+	adminService entity.AdminService
+}
+
+func NewAdminHandler(r *gin.RouterGroup, adminService entity.AdminService) {
+	handler := &adminHandler{
+		adminService: adminService,
+	}
+
+	r.GET("/servers", middleware.Require(entity.PermissionServersRead), handler.ListServers)
+	r.GET("/servers/:userPrincipalName", middleware.Require(entity.PermissionServersRead), handler.GetServer)
+	r.POST("/servers/:userPrincipalName/destroy", middleware.Require(entity.PermissionServersWrite), handler.DestroyServer)
+	r.POST("/servers/:userPrincipalName/reset", middleware.Require(entity.PermissionServersWrite), handler.ResetServer)
+}
+
+// ListServers returns the stored servers, filtered by status, region and idleMinutes.
+func (h *adminHandler) ListServers(c *gin.Context) {
+	idleMinutes, err := strconv.Atoi(c.DefaultQuery("idleMinutes", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "idleMinutes must be a number"})
+		return
+	}
+
+	servers, err := h.adminService.ListServers(middleware.Caller(c), entity.AdminServerQuery{
+		Status:      c.Query("status"),
+		Region:      c.Query("region"),
+		IdleMinutes: idleMinutes,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, servers)
+}
+
+func (h *adminHandler) GetServer(c *gin.Context) {
+	server, err := h.adminService.GetServer(middleware.Caller(c), c.Param("userPrincipalName"))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, server)
+}
+
+func (h *adminHandler) DestroyServer(c *gin.Context) {
+	server, err := h.adminService.DestroyServer(middleware.Caller(c), c.Param("userPrincipalName"))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, server)
+}
+
+func (h *adminHandler) ResetServer(c *gin.Context) {
+	server, err := h.adminService.ResetServer(middleware.Caller(c), c.Param("userPrincipalName"))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, server)
+}
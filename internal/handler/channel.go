@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type releaseChannelHandler struct {
+	releaseChannelService entity.ReleaseChannelService
+}
+
+func NewReleaseChannelHandler(r *gin.RouterGroup, admin *gin.RouterGroup, releaseChannelService entity.ReleaseChannelService) {
+	handler := &releaseChannelHandler{
+		releaseChannelService: releaseChannelService,
+	}
+
+	r.GET("/channels", handler.ListReleaseChannels)
+	admin.GET("/channels", middleware.Require(entity.PermissionServersRead), handler.ListReleaseChannelUsers)
+	admin.PUT("/channels/:name", middleware.Require(entity.PermissionChannelsWrite), handler.SetReleaseChannelImage)
+}
+
+// ListReleaseChannels returns the channels users can pick with imageChannel on PUT /server.
+func (h *releaseChannelHandler) ListReleaseChannels(c *gin.Context) {
+	channels, err := h.releaseChannelService.ListReleaseChannels()
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, channels)
+}
+
+// ListReleaseChannelUsers returns the channels with the users on each, for admins.
+func (h *releaseChannelHandler) ListReleaseChannelUsers(c *gin.Context) {
+	channels, err := h.releaseChannelService.ListReleaseChannelUsers(middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, channels)
+}
+
+// SetReleaseChannelImage changes the image of a channel, for admins.
+func (h *releaseChannelHandler) SetReleaseChannelImage(c *gin.Context) {
+	request := struct {
+		Image string `json:"image"`
+	}{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Image == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image is required"})
+		return
+	}
+
+	channel, err := h.releaseChannelService.SetReleaseChannelImage(middleware.Caller(c), c.Param("name"), request.Image)
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorStatus maps the errors the services return to the response status code.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, entity.ErrInvalidLogsRequest),
+		errors.Is(err, entity.ErrInvalidTerminalRequest),
+		errors.Is(err, entity.ErrRegionNotAllowed),
+		errors.Is(err, entity.ErrInvalidName),
+		errors.Is(err, entity.ErrReleaseChannelNotFound),
+		errors.Is(err, entity.ErrServerSizeNotFound),
+		errors.Is(err, entity.ErrInvalidUsageRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, entity.ErrForbidden),
+		errors.Is(err, entity.ErrServerSizeNotAllowed),
+		errors.Is(err, entity.ErrPolicyViolation):
+		return http.StatusForbidden
+	case errors.Is(err, entity.ErrOperationNotFound),
+		errors.Is(err, entity.ErrServerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, entity.ErrInvalidTransition),
+		errors.Is(err, entity.ErrServerLocked):
+		return http.StatusConflict
+	case errors.Is(err, entity.ErrPreflightFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, entity.ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, entity.ErrTooManyOperations):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorBody is the response body of the error. Errors that carry details the client can
+// act on, the failed preflight checks, the operation holding the server or the policy rule
+// that was broken, include them.
+func errorBody(err error) gin.H {
+	body := gin.H{"error": err.Error()}
+
+	var preflightErr *entity.PreflightError
+	if errors.As(err, &preflightErr) {
+		body["preflight"] = preflightErr.Result
+	}
+
+	var policyErr *entity.PolicyViolationError
+	if errors.As(err, &policyErr) {
+		body["policy"] = policyErr
+	}
+
+	var lockedErr *entity.ServerLockedError
+	if errors.As(err, &lockedErr) && lockedErr.Operation != nil {
+		body["operation"] = lockedErr.Operation
+	}
+
+	return body
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type onboardingHandler struct {
+	onboardingService entity.OnboardingService
+}
+
+func NewOnboardingHandler(r *gin.RouterGroup, onboardingService entity.OnboardingService) {
+	handler := &onboardingHandler{
+		onboardingService: onboardingService,
+	}
+
+	r.POST("/onboarding", handler.Onboard)
+}
+
+// Onboard prepares the user's subscription for a server. The result of each step is
+// reported on the returned operation.
+func (h *onboardingHandler) Onboard(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operation, err := h.onboardingService.Onboard(server, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.Header("Location", "/operations/"+operation.Id)
+	c.JSON(http.StatusAccepted, operation)
+}
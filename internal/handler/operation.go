@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keeps idle streams from being closed by proxies while a step is slow.
+const sseHeartbeatInterval = 15 * time.Second
+
+type operationHandler struct {
+	operationService entity.OperationService
+}
+
+func NewOperationHandler(r *gin.RouterGroup, operationService entity.OperationService) {
+	handler := &operationHandler{
+		operationService: operationService,
+	}
+
+	r.GET("/operations/:id", handler.GetOperation)
+	r.GET("/operations/:id/events", handler.StreamOperation)
+}
+
+func (h *operationHandler) GetOperation(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operation, err := h.operationService.GetOperation(c.Param("id"), server.UserPrincipalId)
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, operation)
+}
+
+// StreamOperation pushes the operation's lifecycle events to the client as server-sent
+// events. The first event is a snapshot of the operation; the stream ends once the
+// operation succeeds or fails.
+func (h *operationHandler) StreamOperation(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operation, events, unsubscribe, err := h.operationService.StreamOperation(c.Param("id"), server.UserPrincipalId)
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering.
+
+	c.SSEvent("snapshot", operation)
+	c.Writer.Flush()
+	if operation.IsTerminal() {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return !event.IsTerminal()
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", gin.H{"timestamp": helper.GetTodaysDateTimeISOString()})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -2,7 +2,10 @@ package handler
 
 import (
 	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/middleware"
+	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,8 +20,16 @@ func NewServerHandler(r *gin.RouterGroup, serverService entity.ServerService) {
 	}
 
 	r.GET("/server", handler.GetServer)
+	r.GET("/server/logs", handler.GetServerLogs)
+	r.GET("/server/preflight", handler.Preflight)
+	r.GET("/server/events", handler.ListServerEvents)
+	r.GET("/server/sizes", handler.ListServerSizes)
 	r.PUT("/server", handler.DeployServer)
 	r.DELETE("/server", handler.DestroyServer)
+	r.POST("/server/teardown", handler.TeardownServer)
+	r.POST("/server/stop", handler.StopServer)
+	r.POST("/server/start", handler.StartServer)
+	r.POST("/server/restart", handler.RestartServer)
 
 	r.PUT("/server/activity/:userPrincipalName", handler.UpdateActivityStatus)
 }
@@ -30,15 +41,115 @@ func (h *serverHandler) GetServer(c *gin.Context) {
 		return
 	}
 
-	server, err := h.serverService.GetServer(server)
+	server, err := h.serverService.GetServer(server, middleware.Caller(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), errorBody(err))
 		return
 	}
 
 	c.JSON(200, server)
 }
 
+// Preflight returns the checklist of the server's prerequisites in the user's subscription.
+func (h *serverHandler) Preflight(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.serverService.Preflight(server, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListServerEvents returns a page of the server's history, newest first. The next page is
+// read by passing the nextPageToken of the response as pageToken.
+func (h *serverHandler) ListServerEvents(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+		return
+	}
+
+	page, err := h.serverService.ListServerEvents(server, middleware.Caller(c), entity.EventQuery{
+		UserPrincipalName: c.Query("userPrincipalName"),
+		PageToken:         c.Query("pageToken"),
+		Limit:             limit,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// ListServerSizes returns the sizes users can pick with size on PUT /server and the
+// largest one the caller may deploy.
+func (h *serverHandler) ListServerSizes(c *gin.Context) {
+	c.JSON(http.StatusOK, h.serverService.ListServerSizes(middleware.Caller(c)))
+}
+
+// GetServerLogs returns the logs of one container of the server. With follow=true the
+// logs are streamed as server-sent events until the client disconnects.
+func (h *serverHandler) GetServerLogs(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	containerName := c.DefaultQuery("container", "actlabs")
+
+	tail, err := strconv.Atoi(c.DefaultQuery("tail", "100"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tail must be a number"})
+		return
+	}
+
+	if c.Query("follow") != "true" {
+		logs, err := h.serverService.GetServerLogs(server, middleware.Caller(c), containerName, tail)
+		if err != nil {
+			c.JSON(errorStatus(err), errorBody(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"container": containerName, "logs": logs})
+		return
+	}
+
+	lines, err := h.serverService.FollowServerLogs(c.Request.Context(), server, middleware.Caller(c), containerName, tail)
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering.
+
+	c.Stream(func(w io.Writer) bool {
+		line, ok := <-lines
+		if !ok {
+			return false
+		}
+		c.SSEvent("log", line)
+		return true
+	})
+}
+
 func (h *serverHandler) DeployServer(c *gin.Context) {
 	server := entity.Server{}
 	if err := c.ShouldBindJSON(&server); err != nil {
@@ -46,13 +157,14 @@ func (h *serverHandler) DeployServer(c *gin.Context) {
 		return
 	}
 
-	server, err := h.serverService.DeployServer(server)
+	operation, err := h.serverService.DeployServer(server, middleware.Caller(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), errorBody(err))
 		return
 	}
 
-	c.JSON(200, server)
+	c.Header("Location", "/operations/"+operation.Id)
+	c.JSON(http.StatusAccepted, operation)
 }
 
 func (h *serverHandler) DestroyServer(c *gin.Context) {
@@ -62,19 +174,87 @@ func (h *serverHandler) DestroyServer(c *gin.Context) {
 		return
 	}
 
-	err := h.serverService.DestroyServer(server)
+	err := h.serverService.DestroyServer(server, middleware.Caller(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), errorBody(err))
 		return
 	}
 
 	c.JSON(200, gin.H{"status": "success"})
 }
 
+// TeardownServer removes the server, its managed identity and role assignments and its record.
+func (h *serverHandler) TeardownServer(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operation, err := h.serverService.TeardownServer(server, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.Header("Location", "/operations/"+operation.Id)
+	c.JSON(http.StatusAccepted, operation)
+}
+
+func (h *serverHandler) StopServer(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	server, err := h.serverService.StopServer(server, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.JSON(200, server)
+}
+
+func (h *serverHandler) StartServer(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operation, err := h.serverService.StartServer(server, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.Header("Location", "/operations/"+operation.Id)
+	c.JSON(http.StatusAccepted, operation)
+}
+
+func (h *serverHandler) RestartServer(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operation, err := h.serverService.RestartServer(server, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	c.Header("Location", "/operations/"+operation.Id)
+	c.JSON(http.StatusAccepted, operation)
+}
+
 func (h *serverHandler) UpdateActivityStatus(c *gin.Context) {
 	userPrincipalName := c.Param("userPrincipalName")
 
-	if err := h.serverService.UpdateActivityStatus(userPrincipalName); err != nil {
+	if err := h.serverService.UpdateActivityStatus(userPrincipalName, middleware.Caller(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
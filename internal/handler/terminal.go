@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/middleware"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"golang.org/x/exp/slog"
+)
+
+// Browsers can't set headers on websocket requests, the token is sent as the second
+// subprotocol and "bearer" is echoed back as the accepted one.
+const terminalSubprotocol = "bearer"
+
+// terminalMessage is sent by the browser. Input is forwarded to the container as is.
+type terminalMessage struct {
+	Type string `json:"type"` // "input" or "resize"
+	Data string `json:"data"`
+	Rows int    `json:"rows"`
+	Cols int    `json:"cols"`
+}
+
+// terminalError is sent to the browser when a message can't be acted on.
+type terminalError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+type terminalHandler struct {
+	terminalService entity.TerminalService
+	appConfig       *config.Config
+	upgrader        websocket.Upgrader
+}
+
+func NewTerminalHandler(r *gin.RouterGroup, terminalService entity.TerminalService, appConfig *config.Config, allowedOrigins []string) {
+	handler := &terminalHandler{
+		terminalService: terminalService,
+		appConfig:       appConfig,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{terminalSubprotocol},
+			CheckOrigin: func(r *http.Request) bool {
+				return originAllowed(allowedOrigins, r.Header.Get("Origin"))
+			},
+		},
+	}
+
+	r.GET(middleware.TerminalRoute, handler.Terminal)
+}
+
+// Terminal bridges the browser's websocket to an exec session in the user's container.
+func (h *terminalHandler) Terminal(c *gin.Context) {
+	server := entity.Server{
+		UserPrincipalId:   c.Query("userPrincipalId"),
+		UserPrincipalName: c.Query("userPrincipalName"),
+		SubscriptionId:    c.Query("subscriptionId"),
+		ResourceGroup:     c.Query("resourceGroup"),
+	}
+
+	rows, _ := strconv.Atoi(c.Query("rows"))
+	cols, _ := strconv.Atoi(c.Query("cols"))
+	request := entity.TerminalRequest{
+		ContainerName: c.Query("container"),
+		Command:       c.Query("command"),
+		Rows:          rows,
+		Cols:          cols,
+	}
+
+	session, exec, err := h.terminalService.StartSession(server, request, middleware.Caller(c))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Error("not able to upgrade terminal connection", slog.String("error", err.Error()))
+		h.terminalService.EndSession(session, "upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	upstream, _, err := websocket.DefaultDialer.Dial(exec.WebSocketUri, nil)
+	if err != nil {
+		slog.Error("not able to connect to container exec session", slog.String("error", err.Error()))
+		closeTerminal(conn, websocket.CloseInternalServerErr, "not able to connect to container")
+		h.terminalService.EndSession(session, "not able to connect to container")
+		return
+	}
+	defer upstream.Close()
+
+	// The exec session expects the password as the first message.
+	if err := upstream.WriteMessage(websocket.TextMessage, []byte(exec.Password)); err != nil {
+		closeTerminal(conn, websocket.CloseInternalServerErr, "not able to authenticate with container")
+		h.terminalService.EndSession(session, "not able to authenticate with container")
+		return
+	}
+
+	done := make(chan string, 2)
+
+	// Both directions write to the browser, a websocket takes one writer at a time.
+	var writeMutex sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	// Container to browser.
+	go func() {
+		for {
+			messageType, data, err := upstream.ReadMessage()
+			if err != nil {
+				done <- "container closed the session"
+				return
+			}
+			if err := write(messageType, data); err != nil {
+				done <- "client disconnected"
+				return
+			}
+		}
+	}()
+
+	// Browser to container.
+	go func() {
+		resizeRefused := false
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				done <- "client disconnected"
+				return
+			}
+
+			message := terminalMessage{}
+			if err := json.Unmarshal(data, &message); err != nil {
+				slog.Debug("ignoring malformed terminal message")
+				continue
+			}
+
+			switch message.Type {
+			case "input":
+				if err := upstream.WriteMessage(websocket.TextMessage, []byte(message.Data)); err != nil {
+					done <- "container closed the session"
+					return
+				}
+			case "resize":
+				// ACI can't resize an exec session, the terminal keeps the rows and cols it
+				// was opened with. The browser is told once, it resizes on every window change.
+				if resizeRefused {
+					continue
+				}
+				resizeRefused = true
+				refusal, _ := json.Marshal(terminalError{Type: "resize", Error: "resize unsupported"})
+				if err := write(websocket.TextMessage, refusal); err != nil {
+					done <- "client disconnected"
+					return
+				}
+			}
+		}
+	}()
+
+	timeout := time.NewTimer(time.Duration(h.appConfig.TerminalSessionTimeoutMinutes) * time.Minute)
+	defer timeout.Stop()
+
+	var reason string
+	select {
+	case reason = <-done:
+	case <-timeout.C:
+		reason = "session timed out"
+		closeTerminal(conn, websocket.CloseNormalClosure, reason)
+	}
+
+	h.terminalService.EndSession(session, reason)
+}
+
+func closeTerminal(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// originAllowed matches the origin against the allowed origins, which may contain a single "*" wildcard.
+func originAllowed(allowedOrigins []string, origin string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
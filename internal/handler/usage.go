@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/middleware"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type usageHandler struct {
+	usageService entity.UsageService
+}
+
+func NewUsageHandler(r *gin.RouterGroup, admin *gin.RouterGroup, usageService entity.UsageService) {
+	handler := &usageHandler{
+		usageService: usageService,
+	}
+
+	r.GET("/usage", handler.GetUsage)
+	admin.GET("/usage", middleware.Require(entity.PermissionUsageRead), handler.GetUsageReport)
+}
+
+// GetUsage returns the usage and estimated cost of the user's server by day or month.
+func (h *usageHandler) GetUsage(c *gin.Context) {
+	server := entity.Server{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.usageService.GetUsage(server, middleware.Caller(c), c.DefaultQuery("period", entity.UsagePeriodDaily), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	writeUsageReport(c, report)
+}
+
+// GetUsageReport returns the usage of every user, for admins.
+func (h *usageHandler) GetUsageReport(c *gin.Context) {
+	report, err := h.usageService.GetUsageReport(middleware.Caller(c), c.DefaultQuery("period", entity.UsagePeriodMonthly), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(errorStatus(err), errorBody(err))
+		return
+	}
+
+	writeUsageReport(c, report)
+}
+
+// writeUsageReport writes the report as json, or as csv with format=csv.
+func writeUsageReport(c *gin.Context, report entity.UsageReport) {
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=usage-"+report.Period+"-"+report.From+"-"+report.To+".csv")
+	c.Status(http.StatusOK)
+
+	formatFloat := func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"userPrincipalName", "period", "hours", "cpuHours", "memoryGbHours", "cost", "currency"})
+	for _, bucket := range report.Buckets {
+		w.Write([]string{
+			bucket.UserPrincipalName,
+			bucket.Period,
+			formatFloat(bucket.Hours),
+			formatFloat(bucket.CPUHours),
+			formatFloat(bucket.MemoryGBHours),
+			formatFloat(bucket.Cost),
+			report.Currency,
+		})
+	}
+	w.Flush()
+}
@@ -82,38 +82,57 @@ func GetClaimFromToken(tokenString string, claim string) (string, error) {
 	return value, nil
 }
 
-func ParseToken(tokenString string) (*jwt.Token, error) {
-	// Drop the Bearer prefix if it exists
-	if strings.HasPrefix(tokenString, "Bearer ") {
-		tokenString = strings.Split(tokenString, "Bearer ")[1]
+// TokenKeyFunc returns the key that verifies the signature of a token. It defaults to the
+// Azure AD signing keys and can be replaced, e.g. with RSAKeyFunc, to verify tokens signed
+// locally.
+var TokenKeyFunc jwt.Keyfunc = azureADKeyFunc
+
+func azureADKeyFunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != jwa.RS256.String() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("kid header not found")
 	}
 
 	keySet, err := jwk.Fetch(context.TODO(), "https://login.microsoftonline.com/common/discovery/v2.0/keys")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys %w", err)
+	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if token.Method.Alg() != jwa.RS256.String() {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+	keys, ok := keySet.LookupKeyID(kid)
+	if !ok {
+		return nil, fmt.Errorf("key %v not found", kid)
+	}
 
-		kid, ok := token.Header["kid"].(string)
-		if !ok {
-			return nil, fmt.Errorf("kid header not found")
-		}
+	publicKey := &rsa.PublicKey{}
+	err = keys.Raw(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key")
+	}
 
-		keys, ok := keySet.LookupKeyID(kid)
-		if !ok {
-			return nil, fmt.Errorf("key %v not found", kid)
-		}
+	return publicKey, nil
+}
 
-		publicKey := &rsa.PublicKey{}
-		err = keys.Raw(publicKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse public key")
+// RSAKeyFunc verifies RS256 tokens with the public key.
+func RSAKeyFunc(publicKey *rsa.PublicKey) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwa.RS256.String() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-
 		return publicKey, nil
-	})
+	}
+}
 
+func ParseToken(tokenString string) (*jwt.Token, error) {
+	// Drop the Bearer prefix if it exists
+	if strings.HasPrefix(tokenString, "Bearer ") {
+		tokenString = strings.Split(tokenString, "Bearer ")[1]
+	}
+
+	token, err := jwt.Parse(tokenString, TokenKeyFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -127,55 +146,76 @@ func ParseToken(tokenString string) (*jwt.Token, error) {
 }
 
 func VerifyToken(tokenString string, userObjectId string) (bool, error) {
+	_, err := VerifyTokenClaims(tokenString, userObjectId)
+	return err == nil, err
+}
+
+// VerifyTokenClaims verifies the token like VerifyToken and returns its claims.
+func VerifyTokenClaims(tokenString string, userObjectId string) (jwt.MapClaims, error) {
 
 	token, err := ParseToken(tokenString)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	// Get the claims from the token
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return false, errors.New("invalid claims")
+		return nil, errors.New("invalid claims")
 	}
 
 	// check the oid
 	oid, ok := claims["oid"].(string)
 	if !ok {
-		return false, errors.New("not able to get oid from claims")
+		return nil, errors.New("not able to get oid from claims")
 	}
 	if oid != userObjectId {
-		return false, errors.New("unexpected oid, expected " + userObjectId + " but got " + oid)
+		return nil, errors.New("unexpected oid, expected " + userObjectId + " but got " + oid)
 	}
 
 	// check the audience
 	aud, ok := claims["aud"].(string)
 	if !ok {
-		return false, errors.New("not able to get audience from claims")
+		return nil, errors.New("not able to get audience from claims")
 	}
 	if aud != os.Getenv("AUTH_TOKEN_AUD") {
-		return false, errors.New("unexpected audience, expected " + os.Getenv("AUTH_TOKEN_AUD") + " but got " + aud)
+		return nil, errors.New("unexpected audience, expected " + os.Getenv("AUTH_TOKEN_AUD") + " but got " + aud)
 	}
 
 	// Check the issuer
 	iss, ok := claims["iss"].(string)
 	if !ok {
-		return false, errors.New("not able to get issuer from claims")
+		return nil, errors.New("not able to get issuer from claims")
 	}
 	if iss != os.Getenv("AUTH_TOKEN_ISS") {
-		return false, errors.New("unexpected issuer, expected " + os.Getenv("AUTH_TOKEN_ISS") + " but got " + iss)
+		return nil, errors.New("unexpected issuer, expected " + os.Getenv("AUTH_TOKEN_ISS") + " but got " + iss)
 	}
 
 	// Check the expiration time
 	exp, ok := claims["exp"].(float64)
 	if !ok {
-		return false, errors.New("invalid expiration time")
+		return nil, errors.New("invalid expiration time")
 	}
 	if time.Now().Unix() > int64(exp) {
-		return false, errors.New("token has expired")
+		return nil, errors.New("token has expired")
 	}
 
-	return true, nil
+	return claims, nil
+}
+
+// ClaimStrings returns a claim that holds a list of strings, like roles or groups.
+func ClaimStrings(claims jwt.MapClaims, claim string) []string {
+	values := []string{}
+	list, ok := claims[claim].([]interface{})
+	if !ok {
+		return values
+	}
+	for _, value := range list {
+		if s, ok := value.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
 }
 
 // Return today's date in the format yyyy-mm-dd as string
@@ -196,3 +236,9 @@ func GetTodaysDateTimeISOString() string {
 func UserAlias(userPrincipalName string) string {
 	return strings.Split(userPrincipalName, "@")[0]
 }
+
+// NormalizeRegion returns the ARM name of an Azure region given its ARM or display name,
+// e.g. "East US 2" and "eastus2" both give "eastus2".
+func NormalizeRegion(region string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(region), " ", ""))
+}
@@ -16,10 +16,17 @@ import (
 	"golang.org/x/exp/slog"
 )
 
+// TerminalRoute is the only route that takes the token and the user from the websocket
+// handshake, every other route verifies the token against the user in the body.
+const TerminalRoute = "/server/terminal"
+
 func Auth(rateLimiter *redis_rate.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		slog.Debug("Auth Middleware")
 		accessToken := c.GetHeader("Authorization")
+		if accessToken == "" && isTerminalRequest(c) {
+			accessToken = websocketAccessToken(c)
+		}
 		if accessToken == "" {
 			slog.Error("no auth token provided")
 			allow := handleBadRequest(c, rateLimiter)
@@ -38,17 +45,36 @@ func Auth(rateLimiter *redis_rate.Limiter) gin.HandlerFunc {
 	}
 }
 
+// Browsers can't set headers on websocket requests. The token is sent as a subprotocol
+// instead, "bearer, <token>", which also keeps it out of the request logs.
+func websocketAccessToken(c *gin.Context) string {
+	protocols := strings.Split(c.GetHeader("Sec-WebSocket-Protocol"), ",")
+	if len(protocols) != 2 || strings.TrimSpace(protocols[0]) != "bearer" {
+		return ""
+	}
+	return "Bearer " + strings.TrimSpace(protocols[1])
+}
+
+func isTerminalRequest(c *gin.Context) bool {
+	return c.FullPath() == TerminalRoute && c.IsWebsocket()
+}
+
 func handleAccessToken(c *gin.Context, accessToken string) error {
-	body, _ := io.ReadAll(c.Request.Body)
 	server := entity.Server{}
-	if err := json.Unmarshal(body, &server); err != nil {
-		slog.Error("error binding json", slog.String("error", err.Error()))
-		c.AbortWithStatus(http.StatusBadRequest)
-		return err
-	}
+	if isTerminalRequest(c) {
+		// Websocket requests have no body, the user is identified in the query string.
+		server.UserPrincipalId = c.Query("userPrincipalId")
+	} else {
+		body, _ := io.ReadAll(c.Request.Body)
+		if err := json.Unmarshal(body, &server); err != nil {
+			slog.Error("error binding json", slog.String("error", err.Error()))
+			c.AbortWithStatus(http.StatusBadRequest)
+			return err
+		}
 
-	// Reassign the body so it can be read again in the handler
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		// Reassign the body so it can be read again in the handler
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	}
 
 	splitToken := strings.Split(accessToken, "Bearer ")
 	if len(splitToken) < 2 {
@@ -56,13 +82,21 @@ func handleAccessToken(c *gin.Context, accessToken string) error {
 		return errors.New("found something in the Authorization header, but it's not a bearer token")
 	}
 
-	ok, err := helper.VerifyToken(accessToken, server.UserPrincipalId)
-	if err != nil || !ok {
+	claims, err := helper.VerifyTokenClaims(accessToken, server.UserPrincipalId)
+	if err != nil {
 		slog.Error("token verification failed", slog.String("error", err.Error()))
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 		return err
 	}
 
+	c.Set(callerKey, entity.Caller{
+		PrincipalId: server.UserPrincipalId,
+		IP:          c.ClientIP(),
+		RequestId:   c.GetString(requestIdKey),
+		Roles:       helper.ClaimStrings(claims, "roles"),
+		Groups:      helper.ClaimStrings(claims, "groups"),
+	})
+
 	return nil
 }
 
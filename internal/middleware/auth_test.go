@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/go-redis/redis_rate"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/time/rate"
+)
+
+const (
+	testAudience = "api://actlabs-test"
+	testIssuer   = "https://login.microsoftonline.com/tenant/v2.0"
+	testOid      = "00000000-0000-0000-0000-000000000001"
+)
+
+// testKeys replaces the Azure AD signing keys with a generated key for the test and
+// returns the key to sign tokens with.
+func testKeys(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFunc := helper.TokenKeyFunc
+	helper.TokenKeyFunc = helper.RSAKeyFunc(&key.PublicKey)
+	t.Cleanup(func() { helper.TokenKeyFunc = keyFunc })
+
+	t.Setenv("AUTH_TOKEN_AUD", testAudience)
+	t.Setenv("AUTH_TOKEN_ISS", testIssuer)
+
+	return key
+}
+
+// testClaims are the claims of a valid token, changed by the given function.
+func testClaims(change func(claims jwt.MapClaims)) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"oid": testOid,
+		"aud": testAudience,
+		"iss": testIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if change != nil {
+		change(claims)
+	}
+	return claims
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+// testRouter runs the middleware in the order main does. Bad requests are counted
+// against a redis that isn't there, the fallback lets them all through.
+func testRouter(grants map[string][]entity.Permission) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	rateLimiter := redis_rate.NewLimiter(redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 10 * time.Millisecond,
+		MaxRetries:  -1,
+	}))
+	rateLimiter.Fallback = rate.NewLimiter(rate.Inf, 0)
+
+	router := gin.New()
+	router.Use(RequestId(), Auth(rateLimiter), Authorize(grants))
+
+	// The permissions are not part of the caller's json, they are returned next to it.
+	caller := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"caller": Caller(c), "permissions": Caller(c).Permissions})
+	}
+	router.POST("/server", caller)
+	admin := router.Group("/admin", RequireAny(entity.Permissions...))
+	admin.GET("/servers", Require(entity.PermissionServersRead), caller)
+	admin.POST("/servers/:userPrincipalName/destroy", Require(entity.PermissionServersWrite), caller)
+
+	return router
+}
+
+func serve(router *gin.Engine, method string, path string, token string, oid string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(`{"userPrincipalId":"`+oid+`"}`))
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAuth(t *testing.T) {
+	key := testKeys(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	websocket := http.Header{
+		"Connection": {"Upgrade"},
+		"Upgrade":    {"websocket"},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		oid        string
+		header     http.Header
+		wantStatus int
+	}{
+		{name: "valid token", token: sign(t, key, testClaims(nil)), wantStatus: http.StatusOK},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", token: "Basic dXNlcjpwYXNz", wantStatus: http.StatusUnauthorized},
+		{name: "expired", token: sign(t, key, testClaims(func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() })), wantStatus: http.StatusUnauthorized},
+		{name: "wrong audience", token: sign(t, key, testClaims(func(claims jwt.MapClaims) { claims["aud"] = "api://other" })), wantStatus: http.StatusUnauthorized},
+		{name: "wrong issuer", token: sign(t, key, testClaims(func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" })), wantStatus: http.StatusUnauthorized},
+		{name: "signed with another key", token: sign(t, otherKey, testClaims(nil)), wantStatus: http.StatusUnauthorized},
+		{name: "other user in body", token: sign(t, key, testClaims(nil)), oid: "00000000-0000-0000-0000-000000000002", wantStatus: http.StatusUnauthorized},
+		{name: "websocket headers use the body outside the terminal", token: sign(t, key, testClaims(nil)), path: "/server?userPrincipalId=" + testOid, oid: "00000000-0000-0000-0000-000000000002", header: websocket, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, oid := tt.path, tt.oid
+			if path == "" {
+				path = "/server"
+			}
+			if oid == "" {
+				oid = testOid
+			}
+
+			recorder := serve(testRouter(nil), http.MethodPost, path, tt.token, oid, tt.header)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", recorder.Code, recorder.Body.String(), tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	key := testKeys(t)
+
+	grants := map[string][]entity.Permission{
+		"admin":            {entity.PermissionAll},
+		"support":          {entity.PermissionServersRead, entity.PermissionUsageRead},
+		"ops-group-object": {entity.PermissionServersWrite},
+	}
+
+	tests := []struct {
+		name            string
+		roles           []string
+		groups          []string
+		method          string
+		path            string
+		wantStatus      int
+		wantPermissions []entity.Permission
+	}{
+		{name: "no roles", method: http.MethodGet, path: "/admin/servers", wantStatus: http.StatusForbidden},
+		{name: "unknown role", roles: []string{"Reader"}, method: http.MethodGet, path: "/admin/servers", wantStatus: http.StatusForbidden},
+		{name: "admin role", roles: []string{"Admin"}, method: http.MethodPost, path: "/admin/servers/user@example.com/destroy", wantStatus: http.StatusOK, wantPermissions: []entity.Permission{entity.PermissionAll}},
+		{name: "role with permission", roles: []string{"Support"}, method: http.MethodGet, path: "/admin/servers", wantStatus: http.StatusOK, wantPermissions: []entity.Permission{entity.PermissionServersRead, entity.PermissionUsageRead}},
+		{name: "role without permission", roles: []string{"Support"}, method: http.MethodPost, path: "/admin/servers/user@example.com/destroy", wantStatus: http.StatusForbidden},
+		{name: "group with permission", groups: []string{"OPS-GROUP-OBJECT"}, method: http.MethodPost, path: "/admin/servers/user@example.com/destroy", wantStatus: http.StatusOK, wantPermissions: []entity.Permission{entity.PermissionServersWrite}},
+		{name: "role and group add up", roles: []string{"Support"}, groups: []string{"ops-group-object"}, method: http.MethodPost, path: "/admin/servers/user@example.com/destroy", wantStatus: http.StatusOK, wantPermissions: []entity.Permission{entity.PermissionServersRead, entity.PermissionUsageRead, entity.PermissionServersWrite}},
+		{name: "own server needs no permission", method: http.MethodPost, path: "/server", wantStatus: http.StatusOK, wantPermissions: []entity.Permission{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := sign(t, key, testClaims(func(claims jwt.MapClaims) {
+				claims["roles"] = tt.roles
+				claims["groups"] = tt.groups
+			}))
+
+			recorder := serve(testRouter(grants), tt.method, tt.path, token, testOid, nil)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", recorder.Code, recorder.Body.String(), tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusForbidden {
+				body := struct {
+					Permission  entity.Permission   `json:"permission"`
+					Permissions []entity.Permission `json:"permissions"`
+				}{}
+				if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || (body.Permission == "") == (len(body.Permissions) == 0) {
+					t.Errorf("body = %s, want the missing permission", recorder.Body.String())
+				}
+				return
+			}
+
+			body := struct {
+				Caller      entity.Caller       `json:"caller"`
+				Permissions []entity.Permission `json:"permissions"`
+			}{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Caller.PrincipalId != testOid {
+				t.Errorf("caller = %s, want %s", body.Caller.PrincipalId, testOid)
+			}
+			if len(body.Permissions) != len(tt.wantPermissions) {
+				t.Fatalf("permissions = %v, want %v", body.Permissions, tt.wantPermissions)
+			}
+			for i := range body.Permissions {
+				if body.Permissions[i] != tt.wantPermissions[i] {
+					t.Errorf("permissions = %v, want %v", body.Permissions, tt.wantPermissions)
+				}
+			}
+		})
+	}
+}
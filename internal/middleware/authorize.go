@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
+)
+
+// Authorize grants the caller the permissions of the app roles and groups in their token.
+// It runs after Auth, which identifies the caller.
+func Authorize(grants map[string][]entity.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := Caller(c)
+		caller.Permissions = entity.PermissionsOf(grants, caller.Roles, caller.Groups)
+		c.Set(callerKey, caller)
+		c.Next()
+	}
+}
+
+// Require lets only callers with the permission through. Handlers declare it on the
+// routes that need more than the caller's own server.
+func Require(permission entity.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := Caller(c)
+		if !caller.Can(permission) {
+			slog.Error("caller is missing permission",
+				slog.String("permission", string(permission)),
+				slog.String("callerPrincipalId", caller.PrincipalId),
+				slog.String("path", c.FullPath()),
+			)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": entity.ErrForbidden.Error(), "permission": permission})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAny lets only callers with one of the permissions through. It guards route
+// groups, like the admin routes, whose routes each Require their own permission.
+func RequireAny(permissions ...entity.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := Caller(c)
+		for _, permission := range permissions {
+			if caller.Can(permission) {
+				c.Next()
+				return
+			}
+		}
+
+		slog.Error("caller has none of the permissions",
+			slog.String("callerPrincipalId", caller.PrincipalId),
+			slog.String("path", c.FullPath()),
+		)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": entity.ErrForbidden.Error(), "permissions": permissions})
+	}
+}
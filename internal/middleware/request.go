@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"actlabs-managed-server/internal/entity"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	requestIdHeader = "X-Request-Id"
+	requestIdKey    = "requestId"
+	callerKey       = "caller"
+)
+
+// RequestId gives every request an id, the one sent by the client or a new one, and
+// returns it in the response so that support can find the request in the logs and events.
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(requestIdHeader)
+		if requestId == "" || len(requestId) > 64 {
+			requestId = uuid.NewString()
+		}
+
+		c.Set(requestIdKey, requestId)
+		c.Header(requestIdHeader, requestId)
+		c.Next()
+	}
+}
+
+// Caller returns who made the request, as verified by Auth.
+func Caller(c *gin.Context) entity.Caller {
+	if caller, ok := c.Get(callerKey); ok {
+		return caller.(entity.Caller)
+	}
+
+	return entity.Caller{
+		IP:        c.ClientIP(),
+		RequestId: c.GetString(requestIdKey),
+	}
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Only delete the key if it is still held by the caller.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Only extend the key if it is still held by the caller.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type locker struct {
+	rdb *redis.Client
+}
+
+func NewLocker(rdb *redis.Client) entity.Locker {
+	return &locker{
+		rdb: rdb,
+	}
+}
+
+func (l *locker) Acquire(key string, owner string, ttl time.Duration) (bool, error) {
+	return l.rdb.SetNX(key, owner, ttl).Result()
+}
+
+func (l *locker) Release(key string, owner string) error {
+	return releaseScript.Run(l.rdb, []string{key}, owner).Err()
+}
+
+func (l *locker) Renew(key string, owner string, ttl time.Duration) (bool, error) {
+	renewed, err := renewScript.Run(l.rdb, []string{key}, owner, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (l *locker) Holder(key string) (string, error) {
+	owner, err := l.rdb.Get(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return owner, err
+}
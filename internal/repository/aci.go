@@ -0,0 +1,410 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/auth"
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerinstance/armcontainerinstance"
+	"golang.org/x/exp/slog"
+)
+
+// aciBackend runs each server as an Azure Container Instances container group, "<alias>-aci",
+// with a caddy sidecar terminating TLS in front of the actlabs container.
+type aciBackend struct {
+	auth      *auth.Auth
+	appConfig *config.Config
+}
+
+func newAciBackend(appConfig *config.Config, auth *auth.Auth) entity.ComputeBackend {
+	return &aciBackend{
+		appConfig: appConfig,
+		auth:      auth,
+	}
+}
+
+func (a *aciBackend) Get(server entity.Server) (entity.Server, error) {
+	ctx := context.Background()
+	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return server, err
+	}
+
+	res, err := clientFactory.Get(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return server, err
+	}
+
+	server.Endpoint = *res.Properties.IPAddress.Fqdn
+	server.Region = *res.Location
+	server.ProvisioningState = string(*res.Properties.ProvisioningState)
+
+	return server, nil
+}
+
+func (a *aciBackend) Deploy(server entity.Server) (entity.Server, error) {
+
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return server, err
+	}
+
+	poller, err := clientFactory.BeginCreateOrUpdate(ctx,
+		server.ResourceGroup,
+		server.UserAlias+"-aci", armcontainerinstance.ContainerGroup{
+			Location: to.Ptr(server.Region),
+			Identity: &armcontainerinstance.ContainerGroupIdentity{
+				Type: to.Ptr(armcontainerinstance.ResourceIdentityTypeUserAssigned),
+				UserAssignedIdentities: map[string]*armcontainerinstance.Components10Wh5UdSchemasContainergroupidentityPropertiesUserassignedidentitiesAdditionalproperties{
+					server.ManagedIdentityResourceId: {},
+				},
+			},
+			Properties: &armcontainerinstance.ContainerGroupProperties{
+				// https://learn.microsoft.com/en-us/azure/container-instances/container-instances-init-container
+				InitContainers: []*armcontainerinstance.InitContainerDefinition{
+					{
+						Name: to.Ptr("init"),
+						Properties: &armcontainerinstance.InitContainerPropertiesDefinition{
+							Image: to.Ptr(a.appConfig.InitImage),
+							EnvironmentVariables: []*armcontainerinstance.EnvironmentVariable{
+								{
+									Name:  to.Ptr("CADDY_HOST"),
+									Value: to.Ptr(aciFqdn(server)),
+								},
+							},
+							VolumeMounts: []*armcontainerinstance.VolumeMount{
+								{
+									Name:      to.Ptr("emptydir"),
+									MountPath: to.Ptr("/etc/caddy"),
+								},
+							},
+							Command: []*string{
+								to.Ptr("/bin/sh"),
+								to.Ptr("-c"),
+								to.Ptr("echo -e \"${CADDY_HOST} {\n\treverse_proxy http://localhost:8881\n}\" > /etc/caddy/Caddyfile"),
+							},
+						},
+					},
+				},
+				Containers: []*armcontainerinstance.Container{
+					{
+						Name: to.Ptr("caddy"),
+						Properties: &armcontainerinstance.ContainerProperties{
+							Image: to.Ptr(a.appConfig.CaddyImage),
+							Ports: []*armcontainerinstance.ContainerPort{
+								{
+									Port:     to.Ptr[int32](a.appConfig.HttpPort),
+									Protocol: to.Ptr(armcontainerinstance.ContainerNetworkProtocolTCP),
+								},
+								{
+									Port:     to.Ptr[int32](a.appConfig.HttpsPort),
+									Protocol: to.Ptr(armcontainerinstance.ContainerNetworkProtocolTCP),
+								},
+							},
+							Resources: &armcontainerinstance.ResourceRequirements{
+								Requests: &armcontainerinstance.ResourceRequests{
+									CPU:        to.Ptr[float64](a.appConfig.CaddyCPU),
+									MemoryInGB: to.Ptr[float64](a.appConfig.CaddyMemory),
+								},
+							},
+							VolumeMounts: []*armcontainerinstance.VolumeMount{
+								{
+									Name:      to.Ptr("emptydir"),
+									MountPath: to.Ptr("/etc/caddy"),
+								},
+							},
+						},
+					},
+					{
+						Name: to.Ptr("actlabs"),
+						Properties: &armcontainerinstance.ContainerProperties{
+							Image: to.Ptr(actlabsImage(a.appConfig, server)),
+							Ports: []*armcontainerinstance.ContainerPort{
+								{
+									Port:     to.Ptr[int32](a.appConfig.ActlabsPort),
+									Protocol: to.Ptr(armcontainerinstance.ContainerNetworkProtocolTCP),
+								},
+							},
+							Resources: &armcontainerinstance.ResourceRequirements{
+								Requests: &armcontainerinstance.ResourceRequests{
+									CPU:        to.Ptr[float64](a.appConfig.ServerSize(server.Size).CPU),
+									MemoryInGB: to.Ptr[float64](a.appConfig.ServerSize(server.Size).Memory),
+								},
+							},
+							ReadinessProbe: &armcontainerinstance.ContainerProbe{
+								InitialDelaySeconds: to.Ptr[int32](a.appConfig.ActlabsReadinessProbeInitialDelaySeconds),
+								PeriodSeconds:       to.Ptr[int32](a.appConfig.ActlabsReadinessProbePeriodSeconds),
+								FailureThreshold:    to.Ptr[int32](a.appConfig.ActlabsReadinessProbeFailureThreshold),
+								SuccessThreshold:    to.Ptr[int32](a.appConfig.ActlabsReadinessProbeSuccessThreshold),
+								TimeoutSeconds:      to.Ptr[int32](a.appConfig.ActlabsReadinessProbeTimeoutSeconds),
+								HTTPGet: &armcontainerinstance.ContainerHTTPGet{
+									Path:   to.Ptr(a.appConfig.ReadinessProbePath),
+									Port:   to.Ptr[int32](a.appConfig.ActlabsPort),
+									Scheme: to.Ptr(armcontainerinstance.SchemeHTTP),
+								},
+							},
+							EnvironmentVariables: aciEnvironmentVariables(actlabsEnvironment(a.appConfig, server)),
+							VolumeMounts: []*armcontainerinstance.VolumeMount{
+								{
+									Name:      to.Ptr("emptydir"),
+									MountPath: to.Ptr("/mnt/emptydir"),
+								},
+							},
+						},
+					},
+				},
+				OSType:        to.Ptr(armcontainerinstance.OperatingSystemTypesLinux),
+				RestartPolicy: to.Ptr(armcontainerinstance.ContainerGroupRestartPolicyAlways),
+				IPAddress: &armcontainerinstance.IPAddress{
+					Ports: []*armcontainerinstance.Port{
+						{
+							Port:     to.Ptr[int32](a.appConfig.HttpPort),
+							Protocol: to.Ptr(armcontainerinstance.ContainerGroupNetworkProtocolTCP),
+						},
+						{
+							Port:     to.Ptr[int32](a.appConfig.HttpsPort),
+							Protocol: to.Ptr(armcontainerinstance.ContainerGroupNetworkProtocolTCP),
+						},
+					},
+					Type:         to.Ptr(armcontainerinstance.ContainerGroupIPAddressTypePublic),
+					DNSNameLabel: to.Ptr(aciDNSNameLabel(server)),
+				},
+				Volumes: []*armcontainerinstance.Volume{
+					{
+						Name:     to.Ptr("emptydir"),
+						EmptyDir: &struct{}{},
+					},
+				},
+			},
+		}, nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return server, err
+	}
+
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		slog.Error("failed to pull the result:", err)
+		return server, err
+	}
+
+	server.Endpoint = *resp.Properties.IPAddress.Fqdn
+	server.Region = *resp.Location
+	server.ProvisioningState = *resp.Properties.ProvisioningState
+
+	return server, nil
+}
+
+func aciDNSNameLabel(server entity.Server) string {
+	return server.UserAlias + "-actlabs-aci"
+}
+
+// aciFqdn is the name ACI gives the container group's public IP, caddy requests its
+// certificate for it. The region is the ARM name of the group's location.
+func aciFqdn(server entity.Server) string {
+	return aciDNSNameLabel(server) + "." + helper.NormalizeRegion(server.Region) + ".azurecontainer.io"
+}
+
+func (a *aciBackend) EnsureUp(server entity.Server) error {
+	return ensureServerUp(server.Endpoint, a.appConfig.ReadinessProbePath)
+}
+
+// ensureServerUp calls the server endpoint to check if it is up.
+func ensureServerUp(endpoint string, readinessProbePath string) error {
+	serverEndpoint := "https://" + endpoint + readinessProbePath
+	slog.Info("Checking if server is up: " + serverEndpoint)
+
+	resp, err := http.Get(serverEndpoint)
+	if err != nil {
+		slog.Error("Failed to make HTTP request:", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Server is not up. Status code:", resp.StatusCode)
+		return errors.New("server is not up")
+	}
+
+	return nil
+}
+
+// tail of 0 returns all available logs, up to 4mb.
+func (a *aciBackend) GetLogs(server entity.Server, containerName string, tail int, timestamps bool) (string, error) {
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainersClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return "", err
+	}
+
+	options := &armcontainerinstance.ContainersClientListLogsOptions{
+		Timestamps: to.Ptr(timestamps),
+	}
+	if tail > 0 {
+		options.Tail = to.Ptr(int32(tail))
+	}
+
+	res, err := clientFactory.ListLogs(ctx, server.ResourceGroup, server.UserAlias+"-aci", containerName, options)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return "", err
+	}
+
+	if res.Content == nil {
+		return "", nil
+	}
+
+	return *res.Content, nil
+}
+
+// https://learn.microsoft.com/en-us/azure/container-instances/container-instances-exec
+func (a *aciBackend) ExecuteCommand(server entity.Server, request entity.TerminalRequest) (entity.ContainerExec, error) {
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainersClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return entity.ContainerExec{}, err
+	}
+
+	res, err := clientFactory.ExecuteCommand(ctx, server.ResourceGroup, server.UserAlias+"-aci", request.ContainerName, armcontainerinstance.ContainerExecRequest{
+		Command: to.Ptr(request.Command),
+		TerminalSize: &armcontainerinstance.ContainerExecRequestTerminalSize{
+			Rows: to.Ptr(int32(request.Rows)),
+			Cols: to.Ptr(int32(request.Cols)),
+		},
+	}, nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return entity.ContainerExec{}, err
+	}
+
+	if res.WebSocketURI == nil || res.Password == nil {
+		return entity.ContainerExec{}, errors.New("exec session not returned")
+	}
+
+	// ACI has no API to resize an exec session, the terminal keeps the size it was created with.
+	return entity.ContainerExec{
+		WebSocketUri: *res.WebSocketURI,
+		Password:     *res.Password,
+	}, nil
+}
+
+func (a *aciBackend) Destroy(server entity.Server) error {
+
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	poller, err := clientFactory.BeginDelete(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		slog.Error("failed to pull the result:", err)
+		return err
+	}
+
+	return nil
+}
+
+// Stopping keeps the container group, and with it the DNS label, but releases the compute.
+func (a *aciBackend) Stop(server entity.Server) error {
+
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	_, err = clientFactory.Stop(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (a *aciBackend) Start(server entity.Server) error {
+
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	poller, err := clientFactory.BeginStart(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		slog.Error("failed to pull the result:", err)
+		return err
+	}
+
+	return nil
+}
+
+// Restarts all containers in the group in place, the container group and its IP are kept.
+func (a *aciBackend) Restart(server entity.Server) error {
+
+	ctx := context.Background()
+
+	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, a.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	poller, err := clientFactory.BeginRestart(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		slog.Error("failed to pull the result:", err)
+		return err
+	}
+
+	return nil
+}
+
+func aciEnvironmentVariables(environment []environmentVariable) []*armcontainerinstance.EnvironmentVariable {
+	variables := []*armcontainerinstance.EnvironmentVariable{}
+	for _, variable := range environment {
+		variables = append(variables, &armcontainerinstance.EnvironmentVariable{
+			Name:  to.Ptr(variable.Name),
+			Value: to.Ptr(variable.Value),
+		})
+	}
+	return variables
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"fmt"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/exp/slog"
+)
+
+// Shared by all replicas so that a change by an admin applies to the next deploy anywhere.
+const releaseChannelsKey = "actlabs-managed-server:release-channels"
+
+type releaseChannelRepository struct {
+	rdb *redis.Client
+}
+
+func NewReleaseChannelRepository(rdb *redis.Client) entity.ReleaseChannelRepository {
+	return &releaseChannelRepository{
+		rdb: rdb,
+	}
+}
+
+func (r *releaseChannelRepository) GetReleaseChannelImages() (map[string]string, error) {
+	images, err := r.rdb.HGetAll(releaseChannelsKey).Result()
+	if err != nil {
+		slog.Error("error getting release channels:", err)
+		return nil, fmt.Errorf("error getting release channels %w", err)
+	}
+
+	return images, nil
+}
+
+func (r *releaseChannelRepository) SetReleaseChannelImage(name string, image string) error {
+	if err := r.rdb.HSet(releaseChannelsKey, name, image).Err(); err != nil {
+		slog.Error("error setting release channel image:", err)
+		return fmt.Errorf("error setting release channel image %w", err)
+	}
+
+	return nil
+}
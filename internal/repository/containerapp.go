@@ -0,0 +1,440 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/auth"
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"golang.org/x/exp/slog"
+)
+
+// https://learn.microsoft.com/en-us/rest/api/resource-manager/containerapps/container-apps
+const containerAppsApiVersion = "2023-05-01"
+
+// Minimal shapes of the Microsoft.App resources, only the fields this backend uses.
+type containerApp struct {
+	Location   string                 `json:"location,omitempty"`
+	Identity   *containerAppIdentity  `json:"identity,omitempty"`
+	Properties containerAppProperties `json:"properties"`
+}
+
+type containerAppIdentity struct {
+	Type                   string              `json:"type"`
+	UserAssignedIdentities map[string]struct{} `json:"userAssignedIdentities"`
+}
+
+type containerAppProperties struct {
+	ManagedEnvironmentId string                     `json:"managedEnvironmentId,omitempty"`
+	ProvisioningState    string                     `json:"provisioningState,omitempty"`
+	RunningStatus        string                     `json:"runningStatus,omitempty"`
+	LatestRevisionName   string                     `json:"latestRevisionName,omitempty"`
+	Configuration        *containerAppConfiguration `json:"configuration,omitempty"`
+	Template             *containerAppTemplate      `json:"template,omitempty"`
+}
+
+type containerAppConfiguration struct {
+	ActiveRevisionsMode string               `json:"activeRevisionsMode,omitempty"`
+	Ingress             *containerAppIngress `json:"ingress,omitempty"`
+}
+
+type containerAppIngress struct {
+	External      bool   `json:"external"`
+	TargetPort    int32  `json:"targetPort"`
+	Transport     string `json:"transport,omitempty"`
+	AllowInsecure bool   `json:"allowInsecure"`
+	Fqdn          string `json:"fqdn,omitempty"`
+}
+
+type containerAppTemplate struct {
+	Containers []containerAppContainer `json:"containers"`
+	Scale      *containerAppScale      `json:"scale,omitempty"`
+}
+
+type containerAppContainer struct {
+	Name      string                `json:"name"`
+	Image     string                `json:"image"`
+	Env       []containerAppEnv     `json:"env,omitempty"`
+	Resources containerAppResources `json:"resources"`
+	Probes    []containerAppProbe   `json:"probes,omitempty"`
+}
+
+type containerAppEnv struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type containerAppResources struct {
+	CPU    float64 `json:"cpu"`
+	Memory string  `json:"memory"`
+}
+
+type containerAppProbe struct {
+	Type                string                   `json:"type"`
+	HTTPGet             containerAppProbeHTTPGet `json:"httpGet"`
+	InitialDelaySeconds int32                    `json:"initialDelaySeconds"`
+	PeriodSeconds       int32                    `json:"periodSeconds"`
+	TimeoutSeconds      int32                    `json:"timeoutSeconds"`
+	SuccessThreshold    int32                    `json:"successThreshold"`
+	FailureThreshold    int32                    `json:"failureThreshold"`
+}
+
+type containerAppProbeHTTPGet struct {
+	Path string `json:"path"`
+	Port int32  `json:"port"`
+}
+
+type containerAppScale struct {
+	MinReplicas int32 `json:"minReplicas"`
+	MaxReplicas int32 `json:"maxReplicas"`
+}
+
+type managedEnvironment struct {
+	Id         string         `json:"id,omitempty"`
+	Location   string         `json:"location"`
+	Properties map[string]any `json:"properties"`
+}
+
+// containerAppBackend runs each server as an Azure Container App, "<alias>-aca", in an
+// environment shared by all servers of the subscription. The managed ingress terminates
+// TLS so there is no caddy sidecar, and the app scales to zero when it is not used.
+type containerAppBackend struct {
+	auth      *auth.Auth
+	appConfig *config.Config
+	client    *arm.Client
+}
+
+func newContainerAppBackend(appConfig *config.Config, auth *auth.Auth) (entity.ComputeBackend, error) {
+	// There is no SDK module for Microsoft.App in use here, the ARM client talks to the REST API directly.
+	client, err := arm.NewClient("actlabs-managed-server/repository", "v1.0.0", auth.Cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("not able to create container apps client %w", err)
+	}
+
+	return &containerAppBackend{
+		appConfig: appConfig,
+		auth:      auth,
+		client:    client,
+	}, nil
+}
+
+func containerAppName(server entity.Server) string {
+	return strings.ToLower(server.UserAlias) + "-aca"
+}
+
+// The names are validated with the request, they are escaped anyway so that a name
+// can't change the resource the path points to.
+func (c *containerAppBackend) appPath(server entity.Server) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.App/containerApps/%s",
+		url.PathEscape(server.SubscriptionId), url.PathEscape(server.ResourceGroup), url.PathEscape(containerAppName(server)))
+}
+
+func (c *containerAppBackend) environmentPath(server entity.Server) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.App/managedEnvironments/%s",
+		url.PathEscape(server.SubscriptionId), url.PathEscape(server.ResourceGroup), url.PathEscape(c.appConfig.ContainerAppsEnvironmentName))
+}
+
+func (c *containerAppBackend) Get(server entity.Server) (entity.Server, error) {
+	app, err := c.getApp(server)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return server, err
+	}
+
+	return containerAppToServer(server, app), nil
+}
+
+func (c *containerAppBackend) Deploy(server entity.Server) (entity.Server, error) {
+	ctx := context.Background()
+
+	environmentId, err := c.ensureEnvironment(server)
+	if err != nil {
+		slog.Error("failed to create container apps environment:", err)
+		return server, err
+	}
+
+	actlabsEnv := []containerAppEnv{}
+	for _, variable := range actlabsEnvironment(c.appConfig, server) {
+		actlabsEnv = append(actlabsEnv, containerAppEnv{Name: variable.Name, Value: variable.Value})
+	}
+
+	app := containerApp{
+		Location: server.Region,
+		Identity: &containerAppIdentity{
+			Type: "UserAssigned",
+			UserAssignedIdentities: map[string]struct{}{
+				server.ManagedIdentityResourceId: {},
+			},
+		},
+		Properties: containerAppProperties{
+			ManagedEnvironmentId: environmentId,
+			Configuration: &containerAppConfiguration{
+				ActiveRevisionsMode: "Single",
+				Ingress: &containerAppIngress{
+					External:   true,
+					TargetPort: c.appConfig.ActlabsPort,
+					Transport:  "auto",
+				},
+			},
+			Template: &containerAppTemplate{
+				Containers: []containerAppContainer{
+					{
+						Name:  "actlabs",
+						Image: actlabsImage(c.appConfig, server),
+						Env:   actlabsEnv,
+						Resources: containerAppResources{
+							// Container Apps only accepts 2Gi of memory per core, sizes used with
+							// this backend are configured that way.
+							CPU:    c.appConfig.ServerSize(server.Size).CPU,
+							Memory: fmt.Sprintf("%gGi", c.appConfig.ServerSize(server.Size).Memory),
+						},
+						Probes: []containerAppProbe{
+							{
+								Type: "Readiness",
+								HTTPGet: containerAppProbeHTTPGet{
+									Path: c.appConfig.ReadinessProbePath,
+									Port: c.appConfig.ActlabsPort,
+								},
+								InitialDelaySeconds: c.appConfig.ActlabsReadinessProbeInitialDelaySeconds,
+								PeriodSeconds:       c.appConfig.ActlabsReadinessProbePeriodSeconds,
+								TimeoutSeconds:      c.appConfig.ActlabsReadinessProbeTimeoutSeconds,
+								SuccessThreshold:    c.appConfig.ActlabsReadinessProbeSuccessThreshold,
+								FailureThreshold:    c.appConfig.ActlabsReadinessProbeFailureThreshold,
+							},
+						},
+					},
+				},
+				Scale: &containerAppScale{
+					MinReplicas: 0,
+					MaxReplicas: 1,
+				},
+			},
+		},
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, c.appPath(server), app, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return server, err
+	}
+
+	poller, err := runtime.NewPoller[containerApp](resp, c.client.Pipeline(), nil)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return server, err
+	}
+
+	app, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		slog.Error("failed to pull the result:", err)
+		return server, err
+	}
+
+	return containerAppToServer(server, app), nil
+}
+
+func (c *containerAppBackend) Destroy(server entity.Server) error {
+	ctx := context.Background()
+
+	resp, err := c.do(ctx, http.MethodDelete, c.appPath(server), nil, http.StatusOK, http.StatusAccepted, http.StatusNoContent)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	return c.pollUntilDone(ctx, resp)
+}
+
+func (c *containerAppBackend) Stop(server entity.Server) error {
+	ctx := context.Background()
+
+	resp, err := c.do(ctx, http.MethodPost, c.appPath(server)+"/stop", nil, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	return c.pollUntilDone(ctx, resp)
+}
+
+func (c *containerAppBackend) Start(server entity.Server) error {
+	ctx := context.Background()
+
+	resp, err := c.do(ctx, http.MethodPost, c.appPath(server)+"/start", nil, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	return c.pollUntilDone(ctx, resp)
+}
+
+// Restart restarts the active revision, which is the only one in single revision mode.
+func (c *containerAppBackend) Restart(server entity.Server) error {
+	ctx := context.Background()
+
+	app, err := c.getApp(server)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	if app.Properties.LatestRevisionName == "" {
+		return errors.New("container app has no revision to restart")
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, c.appPath(server)+"/revisions/"+app.Properties.LatestRevisionName+"/restart", nil, http.StatusOK)
+	if err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (c *containerAppBackend) EnsureUp(server entity.Server) error {
+	return ensureServerUp(server.Endpoint, c.appConfig.ReadinessProbePath)
+}
+
+// Container Apps logs go to Log Analytics rather than being served by the app.
+func (c *containerAppBackend) GetLogs(server entity.Server, containerName string, tail int, timestamps bool) (string, error) {
+	return "", entity.ErrNotSupported
+}
+
+func (c *containerAppBackend) ExecuteCommand(server entity.Server, request entity.TerminalRequest) (entity.ContainerExec, error) {
+	return entity.ContainerExec{}, entity.ErrNotSupported
+}
+
+func (c *containerAppBackend) getApp(server entity.Server) (containerApp, error) {
+	app := containerApp{}
+
+	resp, err := c.do(context.Background(), http.MethodGet, c.appPath(server), nil, http.StatusOK)
+	if err != nil {
+		return app, err
+	}
+
+	if err := runtime.UnmarshalAsJSON(resp, &app); err != nil {
+		return app, err
+	}
+
+	return app, nil
+}
+
+// ensureEnvironment returns the id of the subscription's shared environment, creating it
+// in the server's resource group and region when it doesn't exist yet.
+func (c *containerAppBackend) ensureEnvironment(server entity.Server) (string, error) {
+	ctx := context.Background()
+
+	environment := managedEnvironment{}
+	resp, err := c.do(ctx, http.MethodGet, c.environmentPath(server), nil, http.StatusOK)
+	if err == nil {
+		if err := runtime.UnmarshalAsJSON(resp, &environment); err != nil {
+			return "", err
+		}
+		return environment.Id, nil
+	}
+
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusNotFound {
+		return "", err
+	}
+
+	slog.Info("Container apps environment not found, creating...")
+
+	resp, err = c.do(ctx, http.MethodPut, c.environmentPath(server), managedEnvironment{
+		Location:   server.Region,
+		Properties: map[string]any{},
+	}, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+
+	poller, err := runtime.NewPoller[managedEnvironment](resp, c.client.Pipeline(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	environment, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return environment.Id, nil
+}
+
+// do sends a request to the ARM endpoint and fails unless the response has one of the expected status codes.
+func (c *containerAppBackend) do(ctx context.Context, method string, path string, body any, statusCodes ...int) (*http.Response, error) {
+	req, err := runtime.NewRequest(ctx, method, runtime.JoinPaths(c.client.Endpoint(), path))
+	if err != nil {
+		return nil, err
+	}
+
+	query := req.Raw().URL.Query()
+	query.Set("api-version", containerAppsApiVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+
+	if body != nil {
+		if err := runtime.MarshalAsJSON(req, body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !runtime.HasStatusCode(resp, statusCodes...) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	return resp, nil
+}
+
+func (c *containerAppBackend) pollUntilDone(ctx context.Context, resp *http.Response) error {
+	if resp.StatusCode != http.StatusAccepted {
+		resp.Body.Close()
+		return nil
+	}
+
+	poller, err := runtime.NewPoller[struct{}](resp, c.client.Pipeline(), nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		slog.Error("failed to pull the result:", err)
+		return err
+	}
+
+	return nil
+}
+
+func containerAppToServer(server entity.Server, app containerApp) entity.Server {
+	if app.Properties.Configuration != nil && app.Properties.Configuration.Ingress != nil {
+		server.Endpoint = app.Properties.Configuration.Ingress.Fqdn
+	}
+
+	// ARM returns the display name of the region for container apps.
+	if app.Location != "" {
+		server.Region = helper.NormalizeRegion(app.Location)
+	}
+
+	server.ProvisioningState = app.Properties.ProvisioningState
+	if app.Properties.RunningStatus != "" {
+		server.ProvisioningState = app.Properties.RunningStatus
+	}
+
+	return server
+}
@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// dockerBackend runs each server on a local docker daemon the same way it runs on ACI:
+// an init container writes the Caddyfile to a shared volume, caddy publishes the server
+// and actlabs shares caddy's network namespace so that caddy reaches it on localhost.
+type dockerBackend struct {
+	appConfig *config.Config
+	client    *http.Client
+	baseURL   string
+}
+
+func newDockerBackend(appConfig *config.Config) (entity.ComputeBackend, error) {
+	hostURL, err := url.Parse(appConfig.DockerHost)
+	if err != nil {
+		return nil, fmt.Errorf("not able to parse DOCKER_HOST %w", err)
+	}
+
+	d := &dockerBackend{
+		appConfig: appConfig,
+	}
+
+	switch hostURL.Scheme {
+	case "unix":
+		socket := hostURL.Path
+		d.baseURL = "http://docker"
+		d.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	case "tcp", "http":
+		d.baseURL = "http://" + hostURL.Host
+		d.client = &http.Client{}
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST scheme %s", hostURL.Scheme)
+	}
+
+	return d, nil
+}
+
+// Docker names are letters, numbers, underscores, periods and hyphens. The alias is
+// validated with the request, anything else is replaced anyway since the names end up in
+// the Docker Engine API paths.
+var dockerNameInvalidChars = regexp.MustCompile("[^a-zA-Z0-9_.-]")
+
+func dockerName(server entity.Server, container string) string {
+	return dockerNameInvalidChars.ReplaceAllString(server.UserAlias, "-") + "-aci-" + container
+}
+
+func dockerVolumeName(server entity.Server) string {
+	return dockerNameInvalidChars.ReplaceAllString(server.UserAlias, "-") + "-aci-emptydir"
+}
+
+func (d *dockerBackend) Get(server entity.Server) (entity.Server, error) {
+	container, err := d.inspect(dockerName(server, "caddy"))
+	if err != nil {
+		slog.Error("failed to inspect container:", err)
+		return server, err
+	}
+
+	return dockerToServer(server, container), nil
+}
+
+func (d *dockerBackend) Deploy(server entity.Server) (entity.Server, error) {
+	// Like ACI create or update, deploying again replaces the existing containers.
+	if err := d.Destroy(server); err != nil {
+		return server, err
+	}
+
+	for _, image := range []string{d.appConfig.InitImage, d.appConfig.CaddyImage, actlabsImage(d.appConfig, server)} {
+		if err := d.pull(image); err != nil {
+			slog.Error("failed to pull image:", err)
+			return server, err
+		}
+	}
+
+	if _, err := d.do(http.MethodPost, "/volumes/create", nil, map[string]any{"Name": dockerVolumeName(server)}, http.StatusCreated); err != nil {
+		slog.Error("failed to create volume:", err)
+		return server, err
+	}
+
+	labels := map[string]string{"actlabs.server": server.UserAlias}
+
+	// The init container is kept after it exits so that its logs can be read.
+	if err := d.createAndStart(dockerName(server, "init"), map[string]any{
+		"Image":  d.appConfig.InitImage,
+		"Env":    []string{"USER_ALIAS=" + server.UserAlias},
+		"Cmd":    []string{"/bin/sh", "-c", fmt.Sprintf("echo -e \":%d {\\n\\treverse_proxy http://localhost:%d\\n}\" > /etc/caddy/Caddyfile", d.appConfig.HttpPort, d.appConfig.ActlabsPort)},
+		"Labels": labels,
+		"HostConfig": map[string]any{
+			"Binds": []string{dockerVolumeName(server) + ":/etc/caddy"},
+		},
+	}); err != nil {
+		return server, err
+	}
+
+	if _, err := d.do(http.MethodPost, "/containers/"+url.PathEscape(dockerName(server, "init"))+"/wait", nil, nil, http.StatusOK); err != nil {
+		slog.Error("failed to wait for init container:", err)
+		return server, err
+	}
+
+	httpPort := strconv.Itoa(int(d.appConfig.HttpPort)) + "/tcp"
+	if err := d.createAndStart(dockerName(server, "caddy"), map[string]any{
+		"Image":        d.appConfig.CaddyImage,
+		"Labels":       labels,
+		"ExposedPorts": map[string]any{httpPort: map[string]any{}},
+		"HostConfig": map[string]any{
+			"Binds": []string{dockerVolumeName(server) + ":/etc/caddy"},
+			// Let docker pick a free port on the host so that several servers can run side by side.
+			"PortBindings":  map[string]any{httpPort: []map[string]string{{"HostIp": "127.0.0.1", "HostPort": ""}}},
+			"RestartPolicy": map[string]string{"Name": "always"},
+		},
+	}); err != nil {
+		return server, err
+	}
+
+	env := []string{}
+	for _, variable := range actlabsEnvironment(d.appConfig, server) {
+		env = append(env, variable.Name+"="+variable.Value)
+	}
+
+	size := d.appConfig.ServerSize(server.Size)
+	if err := d.createAndStart(dockerName(server, "actlabs"), map[string]any{
+		"Image":  actlabsImage(d.appConfig, server),
+		"Env":    env,
+		"Labels": labels,
+		"HostConfig": map[string]any{
+			"Binds":         []string{dockerVolumeName(server) + ":/mnt/emptydir"},
+			"NetworkMode":   "container:" + dockerName(server, "caddy"),
+			"RestartPolicy": map[string]string{"Name": "always"},
+			// Limit the container to the server's size like ACI does.
+			"NanoCpus": int64(size.CPU * 1e9),
+			"Memory":   int64(size.Memory * 1024 * 1024 * 1024),
+		},
+	}); err != nil {
+		return server, err
+	}
+
+	return d.Get(server)
+}
+
+func (d *dockerBackend) Destroy(server entity.Server) error {
+	for _, container := range []string{"actlabs", "caddy", "init"} {
+		if _, err := d.do(http.MethodDelete, "/containers/"+url.PathEscape(dockerName(server, container))+"?force=true", nil, nil, http.StatusNoContent, http.StatusNotFound); err != nil {
+			slog.Error("failed to remove container:", err)
+			return err
+		}
+	}
+
+	if _, err := d.do(http.MethodDelete, "/volumes/"+url.PathEscape(dockerVolumeName(server)), nil, nil, http.StatusNoContent, http.StatusNotFound); err != nil {
+		slog.Error("failed to remove volume:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (d *dockerBackend) Stop(server entity.Server) error {
+	for _, container := range []string{"actlabs", "caddy"} {
+		if _, err := d.do(http.MethodPost, "/containers/"+url.PathEscape(dockerName(server, container))+"/stop", nil, nil, http.StatusNoContent, http.StatusNotModified); err != nil {
+			slog.Error("failed to stop container:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dockerBackend) Start(server entity.Server) error {
+	// caddy owns the network namespace, it has to be up before actlabs joins it.
+	for _, container := range []string{"caddy", "actlabs"} {
+		if _, err := d.do(http.MethodPost, "/containers/"+url.PathEscape(dockerName(server, container))+"/start", nil, nil, http.StatusNoContent, http.StatusNotModified); err != nil {
+			slog.Error("failed to start container:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dockerBackend) Restart(server entity.Server) error {
+	for _, container := range []string{"caddy", "actlabs"} {
+		if _, err := d.do(http.MethodPost, "/containers/"+url.PathEscape(dockerName(server, container))+"/restart", nil, nil, http.StatusNoContent); err != nil {
+			slog.Error("failed to restart container:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// caddy serves plain http locally, there is no certificate for localhost.
+func (d *dockerBackend) EnsureUp(server entity.Server) error {
+	resp, err := http.Get("http://" + server.Endpoint + d.appConfig.ReadinessProbePath)
+	if err != nil {
+		slog.Error("Failed to make HTTP request:", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Server is not up. Status code:", resp.StatusCode)
+		return errors.New("server is not up")
+	}
+
+	return nil
+}
+
+func (d *dockerBackend) GetLogs(server entity.Server, containerName string, tail int, timestamps bool) (string, error) {
+	query := url.Values{}
+	query.Set("stdout", "true")
+	query.Set("stderr", "true")
+	query.Set("timestamps", strconv.FormatBool(timestamps))
+	if tail > 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	}
+
+	body, err := d.do(http.MethodGet, "/containers/"+url.PathEscape(dockerName(server, containerName))+"/logs?"+query.Encode(), nil, nil, http.StatusOK)
+	if err != nil {
+		slog.Error("failed to get container logs:", err)
+		return "", err
+	}
+
+	return demuxDockerLogs(body), nil
+}
+
+// The terminal speaks the ACI exec websocket protocol which docker doesn't offer.
+func (d *dockerBackend) ExecuteCommand(server entity.Server, request entity.TerminalRequest) (entity.ContainerExec, error) {
+	return entity.ContainerExec{}, entity.ErrNotSupported
+}
+
+type dockerContainer struct {
+	State struct {
+		Status string `json:"Status"`
+	} `json:"State"`
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostIp   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+func (d *dockerBackend) inspect(name string) (dockerContainer, error) {
+	container := dockerContainer{}
+
+	body, err := d.do(http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil, http.StatusOK)
+	if err != nil {
+		return container, err
+	}
+
+	if err := json.Unmarshal(body, &container); err != nil {
+		return container, err
+	}
+
+	return container, nil
+}
+
+func (d *dockerBackend) pull(image string) error {
+	// The tag follows the last colon after the last slash, a registry may have a port.
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag)
+
+	_, err := d.do(http.MethodPost, "/images/create?"+query.Encode(), nil, nil, http.StatusOK)
+	return err
+}
+
+func (d *dockerBackend) createAndStart(name string, spec map[string]any) error {
+	if _, err := d.do(http.MethodPost, "/containers/create?name="+url.QueryEscape(name), nil, spec, http.StatusCreated); err != nil {
+		slog.Error("failed to create container:", err)
+		return err
+	}
+
+	if _, err := d.do(http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil, nil, http.StatusNoContent); err != nil {
+		slog.Error("failed to start container:", err)
+		return err
+	}
+
+	return nil
+}
+
+// do calls the docker engine API and returns the response body. It fails unless the
+// response has one of the expected status codes.
+func (d *dockerBackend) do(method string, path string, header http.Header, body any, statusCodes ...int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		val, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(val)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Image pulls report progress in the body until they are done, so it is always read to the end.
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statusCode := range statusCodes {
+		if resp.StatusCode == statusCode {
+			return respBody, nil
+		}
+	}
+
+	return nil, fmt.Errorf("docker %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+func dockerToServer(server entity.Server, container dockerContainer) entity.Server {
+	// Stopped containers have no published ports, the endpoint is left as it was.
+	for port, bindings := range container.NetworkSettings.Ports {
+		if len(bindings) > 0 && strings.HasSuffix(port, "/tcp") {
+			server.Endpoint = "localhost:" + bindings[0].HostPort
+			break
+		}
+	}
+
+	// Report the same states as ACI does.
+	switch container.State.Status {
+	case "running":
+		server.ProvisioningState = "Succeeded"
+	case "exited":
+		server.ProvisioningState = "Stopped"
+	default:
+		server.ProvisioningState = container.State.Status
+	}
+
+	return server
+}
+
+// demuxDockerLogs strips the 8 byte headers docker puts in front of each frame of a
+// container's output when the container has no tty.
+func demuxDockerLogs(body []byte) string {
+	var logs strings.Builder
+	for len(body) >= 8 {
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		body = body[8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		logs.Write(body[:size])
+		body = body[size:]
+	}
+	return logs.String()
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"strconv"
+)
+
+type environmentVariable struct {
+	Name  string
+	Value string
+}
+
+// actlabsEnvironment is the environment of the actlabs container, shared by all compute backends.
+func actlabsEnvironment(appConfig *config.Config, server entity.Server) []environmentVariable {
+	return []environmentVariable{
+		{Name: "ARM_USE_MSI", Value: strconv.FormatBool(appConfig.UseMsi)},
+		{Name: "USE_MSI", Value: strconv.FormatBool(appConfig.UseMsi)},
+		{Name: "PROTECTED_LAB_SECRET", Value: appConfig.ProtectedLabSecret},
+		{Name: "ACTLABS_AUTH_URL", Value: appConfig.ActlabsAuthURL},
+		{Name: "PORT", Value: strconv.Itoa(int(appConfig.ActlabsPort))},
+		{Name: "ROOT_DIR", Value: appConfig.ActlabsRootDir},
+		{Name: "AZURE_CLIENT_ID", Value: server.ManagedIdentityClientId}, // https://github.com/microsoft/azure-container-apps/issues/442
+		{Name: "ARM_SUBSCRIPTION_ID", Value: server.SubscriptionId},
+		{Name: "AZURE_SUBSCRIPTION_ID", Value: server.SubscriptionId},
+		{Name: "ARM_TENANT_ID", Value: appConfig.TenantID},
+		{Name: "ARM_USER_PRINCIPAL_NAME", Value: server.UserPrincipalName},
+		{Name: "LOG_LEVEL", Value: server.LogLevel},
+		{Name: "AUTH_TOKEN_ISS", Value: appConfig.AuthTokenIss},
+		{Name: "AUTH_TOKEN_AUD", Value: appConfig.AuthTokenAud},
+	}
+}
+
+// actlabsImage is the actlabs image of the server's release channel. Servers deployed
+// before channels existed get the default channel's image from config.
+func actlabsImage(appConfig *config.Config, server entity.Server) string {
+	if server.Image != "" {
+		return server.Image
+	}
+	return appConfig.ReleaseChannels[appConfig.DefaultReleaseChannel]
+}
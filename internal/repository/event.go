@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/auth"
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+	"golang.org/x/exp/slog"
+)
+
+const sqlServerEventsSchema = `CREATE TABLE IF NOT EXISTS server_events (
+	partition_key TEXT NOT NULL,
+	row_key TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (partition_key, row_key)
+)`
+
+// NewEventStore returns the event store next to the configured server store. The
+// credential is only used by the table store and may be nil otherwise.
+func NewEventStore(appConfig *config.Config, cred azcore.TokenCredential) (entity.EventRepository, error) {
+	switch appConfig.ServerStore {
+	case ServerStoreTable:
+		return newTableEventStore(appConfig, cred)
+	case ServerStoreSqlite, ServerStorePostgres:
+		db, driver, err := openSqlDB(appConfig.ServerStore, appConfig.ServerStoreDSN, sqlServerEventsSchema)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlEventStore{db: db, driver: driver}, nil
+	default:
+		return nil, fmt.Errorf("unknown server store %s", appConfig.ServerStore)
+	}
+}
+
+// tableEventStore keeps the events in a second table of the actlabs storage account.
+type tableEventStore struct {
+	client *aztables.Client
+}
+
+func newTableEventStore(appConfig *config.Config, cred azcore.TokenCredential) (entity.EventRepository, error) {
+	if cred == nil {
+		return nil, errors.New("table event store needs azure credentials")
+	}
+
+	client, err := auth.GetTableClient(
+		appConfig.ActlabsSubscriptionID,
+		cred,
+		appConfig.ActlabsResourceGroup,
+		appConfig.ActlabsStorageAccount,
+		appConfig.ActlabsServerEventsTableName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("not able to create table client %w", err)
+	}
+
+	// Unlike the servers table, the events table is new and may not have been created yet.
+	if _, err := client.CreateTable(context.Background(), nil); err != nil {
+		var responseErr *azcore.ResponseError
+		if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusConflict {
+			return nil, fmt.Errorf("not able to create events table %w", err)
+		}
+	}
+
+	return &tableEventStore{
+		client: client,
+	}, nil
+}
+
+func (t *tableEventStore) AppendEvent(event entity.ServerEvent) error {
+	val, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("error marshalling event:", err)
+		return fmt.Errorf("error marshalling event %w", err)
+	}
+
+	// Add, not upsert, the history is append-only.
+	if _, err := t.client.AddEntity(context.Background(), val, nil); err != nil {
+		slog.Error("error adding event:", err)
+		return fmt.Errorf("error adding event %w", err)
+	}
+
+	return nil
+}
+
+func (t *tableEventStore) ListEvents(query entity.EventQuery) (entity.EventPage, error) {
+	filter := "PartitionKey eq '" + escapeODataString(query.UserPrincipalName) + "'"
+	if query.PageToken != "" {
+		filter += " and RowKey gt '" + escapeODataString(query.PageToken) + "'"
+	}
+
+	// One more than the page is read to know whether there is a next page.
+	pager := t.client.NewListEntitiesPager(&aztables.ListEntitiesOptions{
+		Filter: to.Ptr(filter),
+		Top:    to.Ptr(int32(query.Limit + 1)),
+	})
+
+	events := []entity.ServerEvent{}
+	for pager.More() && len(events) <= query.Limit {
+		response, err := pager.NextPage(context.Background())
+		if err != nil {
+			slog.Error("error listing events from database:", err)
+			return entity.EventPage{}, fmt.Errorf("error listing events from database %w", err)
+		}
+
+		for _, value := range response.Entities {
+			event := entity.ServerEvent{}
+			if err := json.Unmarshal(value, &event); err != nil {
+				slog.Error("error unmarshalling event:", err)
+				return entity.EventPage{}, fmt.Errorf("error unmarshalling event %w", err)
+			}
+			events = append(events, event)
+		}
+	}
+
+	return eventPage(events, query.Limit), nil
+}
+
+// sqlEventStore keeps the events in the SQLite or PostgreSQL database of the server store.
+type sqlEventStore struct {
+	db     *sql.DB
+	driver string
+}
+
+func (s *sqlEventStore) AppendEvent(event entity.ServerEvent) error {
+	val, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("error marshalling event:", err)
+		return fmt.Errorf("error marshalling event %w", err)
+	}
+
+	_, err = s.db.Exec(rebind(s.driver, `INSERT INTO server_events (partition_key, row_key, data) VALUES (?, ?, ?)`),
+		event.PartitionKey, event.RowKey, string(val),
+	)
+	if err != nil {
+		slog.Error("error adding event:", err)
+		return fmt.Errorf("error adding event %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqlEventStore) ListEvents(query entity.EventQuery) (entity.EventPage, error) {
+	rows, err := s.db.Query(rebind(s.driver, `SELECT data FROM server_events
+		WHERE partition_key = ? AND row_key > ?
+		ORDER BY row_key
+		LIMIT ?`),
+		query.UserPrincipalName, query.PageToken, query.Limit+1,
+	)
+	if err != nil {
+		slog.Error("error listing events from database:", err)
+		return entity.EventPage{}, fmt.Errorf("error listing events from database %w", err)
+	}
+	defer rows.Close()
+
+	events := []entity.ServerEvent{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return entity.EventPage{}, fmt.Errorf("error listing events from database %w", err)
+		}
+
+		event := entity.ServerEvent{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			slog.Error("error unmarshalling event:", err)
+			return entity.EventPage{}, fmt.Errorf("error unmarshalling event %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return entity.EventPage{}, fmt.Errorf("error listing events from database %w", err)
+	}
+
+	return eventPage(events, query.Limit), nil
+}
+
+// eventPage cuts the events read to the page size. The row key of the last event on the
+// page is where the next page starts.
+func eventPage(events []entity.ServerEvent, limit int) entity.EventPage {
+	if len(events) <= limit {
+		return entity.EventPage{Events: events}
+	}
+
+	events = events[:limit]
+	return entity.EventPage{
+		Events:        events,
+		NextPageToken: events[limit-1].RowKey,
+	}
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+
+	"golang.org/x/exp/slog"
+)
+
+// localServerRepository runs servers on the local docker daemon, so the manager can be run
+// end to end on a laptop or in CI without an Azure subscription. There is no managed
+// identity and every user owns every subscription.
+type localServerRepository struct {
+	appConfig *config.Config
+	store     entity.ServerStore
+	backend   entity.ComputeBackend
+}
+
+func NewLocalServerRepository(appConfig *config.Config, store entity.ServerStore) (entity.ServerRepository, error) {
+	backend, err := newDockerBackend(appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Warn("running with the local docker backend, subscription ownership is not verified")
+
+	return &localServerRepository{
+		appConfig: appConfig,
+		store:     store,
+		backend:   backend,
+	}, nil
+}
+
+func (l *localServerRepository) GetAzureContainerGroup(server entity.Server) (entity.Server, error) {
+	return l.backend.Get(server)
+}
+
+func (l *localServerRepository) DeployAzureContainerGroup(server entity.Server) (entity.Server, error) {
+	server.Backend = entity.BackendDocker
+	return l.backend.Deploy(server)
+}
+
+func (l *localServerRepository) EnsureServerUp(server entity.Server) error {
+	return l.backend.EnsureUp(server)
+}
+
+func (l *localServerRepository) GetContainerLogs(server entity.Server, containerName string, tail int, timestamps bool) (string, error) {
+	return l.backend.GetLogs(server, containerName, tail, timestamps)
+}
+
+func (l *localServerRepository) ExecuteContainerCommand(server entity.Server, request entity.TerminalRequest) (entity.ContainerExec, error) {
+	return l.backend.ExecuteCommand(server, request)
+}
+
+func (l *localServerRepository) DestroyAzureContainerGroup(server entity.Server) error {
+	return l.backend.Destroy(server)
+}
+
+func (l *localServerRepository) StopAzureContainerGroup(server entity.Server) error {
+	return l.backend.Stop(server)
+}
+
+func (l *localServerRepository) StartAzureContainerGroup(server entity.Server) error {
+	return l.backend.Start(server)
+}
+
+func (l *localServerRepository) RestartAzureContainerGroup(server entity.Server) error {
+	return l.backend.Restart(server)
+}
+
+func (l *localServerRepository) GetUserAssignedManagedIdentity(server entity.Server) (entity.Server, error) {
+	return localManagedIdentity(server), nil
+}
+
+func (l *localServerRepository) CreateUserAssignedManagedIdentity(server entity.Server) (entity.Server, error) {
+	return localManagedIdentity(server), nil
+}
+
+// There is no subscription to assign roles on, the identity always has the configured roles.
+func (l *localServerRepository) ListManagedIdentityRoleAssignments(server entity.Server) ([]entity.RoleAssignment, error) {
+	roleAssignments := []entity.RoleAssignment{}
+	for _, role := range l.appConfig.ManagedIdentityRoles {
+		roleAssignments = append(roleAssignments, entity.RoleAssignment{
+			Id:               "local-" + role,
+			RoleDefinitionId: role,
+			Scope:            "/subscriptions/" + server.SubscriptionId,
+		})
+	}
+	return roleAssignments, nil
+}
+
+func (l *localServerRepository) CreateManagedIdentityRoleAssignment(server entity.Server, roleDefinitionId string) error {
+	return nil
+}
+
+func (l *localServerRepository) DeleteManagedIdentityRoleAssignment(server entity.Server, roleAssignmentId string) error {
+	return nil
+}
+
+func (l *localServerRepository) DeleteUserAssignedManagedIdentity(server entity.Server) error {
+	return nil
+}
+
+func (l *localServerRepository) IsUserOwner(server entity.Server) (bool, error) {
+	return true, nil
+}
+
+func (l *localServerRepository) UpsertServerInDatabase(server entity.Server) error {
+	server.PartitionKey = "actlabs"
+	server.RowKey = server.UserPrincipalName
+
+	if err := l.store.UpsertServer(server); err != nil {
+		return err
+	}
+
+	slog.Debug("Server upserted in database")
+
+	return nil
+}
+
+func (l *localServerRepository) GetServerFromDatabase(partitionKey string, rowKey string) (entity.Server, error) {
+	return l.store.GetServer(partitionKey, rowKey)
+}
+
+func (l *localServerRepository) ListServersFromDatabase(query entity.ServerQuery) ([]entity.Server, error) {
+	return l.store.ListServers(query)
+}
+
+func (l *localServerRepository) DeleteServerFromDatabase(partitionKey string, rowKey string) error {
+	return l.store.DeleteServer(partitionKey, rowKey)
+}
+
+// There are no managed identities locally, the resource id only marks the step as done.
+func localManagedIdentity(server entity.Server) entity.Server {
+	server.ManagedIdentityResourceId = "local"
+	server.ManagedIdentityClientId = ""
+	server.ManagedIdentityPrincipalId = ""
+	return server
+}
+
+type localOnboardingRepository struct{}
+
+// NewLocalOnboardingRepository has nothing to prepare, every resource already exists.
+func NewLocalOnboardingRepository() entity.OnboardingRepository {
+	return &localOnboardingRepository{}
+}
+
+func (l *localOnboardingRepository) ResourceProviderRegistered(server entity.Server, namespace string) (bool, error) {
+	return true, nil
+}
+
+func (l *localOnboardingRepository) ResourceGroupExists(server entity.Server) (bool, error) {
+	return true, nil
+}
+
+func (l *localOnboardingRepository) BlobContainerExists(server entity.Server, containerName string) (bool, error) {
+	return true, nil
+}
+
+func (l *localOnboardingRepository) EnsureResourceGroup(server entity.Server) (bool, error) {
+	return false, nil
+}
+
+func (l *localOnboardingRepository) GetStorageAccountName(server entity.Server) (string, error) {
+	return "local", nil
+}
+
+func (l *localOnboardingRepository) CreateStorageAccount(server entity.Server, storageAccountName string) error {
+	return nil
+}
+
+func (l *localOnboardingRepository) EnsureBlobContainer(server entity.Server, containerName string) (bool, error) {
+	return false, nil
+}
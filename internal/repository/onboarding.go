@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/auth"
+	"actlabs-managed-server/internal/entity"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"golang.org/x/exp/slog"
+)
+
+type onboardingRepository struct {
+	auth *auth.Auth
+}
+
+func NewOnboardingRepository(auth *auth.Auth) entity.OnboardingRepository {
+	return &onboardingRepository{
+		auth: auth,
+	}
+}
+
+func (o *onboardingRepository) ResourceProviderRegistered(server entity.Server, namespace string) (bool, error) {
+	client, err := armresources.NewProvidersClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return false, err
+	}
+
+	provider, err := client.Get(context.Background(), namespace, nil)
+	if err != nil {
+		slog.Error("failed to get resource provider:", err)
+		return false, err
+	}
+
+	return provider.RegistrationState != nil && *provider.RegistrationState == "Registered", nil
+}
+
+func (o *onboardingRepository) ResourceGroupExists(server entity.Server) (bool, error) {
+	client, err := armresources.NewResourceGroupsClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return false, err
+	}
+
+	exists, err := client.CheckExistence(context.Background(), server.ResourceGroup, nil)
+	if err != nil {
+		slog.Error("failed to check resource group:", err)
+		return false, err
+	}
+
+	return exists.Success, nil
+}
+
+func (o *onboardingRepository) BlobContainerExists(server entity.Server, containerName string) (bool, error) {
+	client, err := armstorage.NewBlobContainersClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return false, err
+	}
+
+	_, err = client.Get(context.Background(), server.ResourceGroup, server.StorageAccountName, containerName, nil)
+	if err == nil {
+		return true, nil
+	}
+
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	slog.Error("failed to get blob container:", err)
+	return false, err
+}
+
+func (o *onboardingRepository) EnsureResourceGroup(server entity.Server) (bool, error) {
+	client, err := armresources.NewResourceGroupsClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return false, err
+	}
+
+	exists, err := o.ResourceGroupExists(server)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if _, err := client.CreateOrUpdate(context.Background(), server.ResourceGroup, armresources.ResourceGroup{
+		Location: to.Ptr(server.Region),
+	}, nil); err != nil {
+		slog.Error("failed to create resource group:", err)
+		return false, err
+	}
+
+	slog.Info("Resource group " + server.ResourceGroup + " created in subscription " + server.SubscriptionId)
+
+	return true, nil
+}
+
+func (o *onboardingRepository) GetStorageAccountName(server entity.Server) (string, error) {
+	client, err := armstorage.NewAccountsClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return "", err
+	}
+
+	pager := client.NewListByResourceGroupPager(server.ResourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			slog.Error("failed to get the next page:", err)
+			return "", err
+		}
+		for _, account := range page.Value {
+			return *account.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (o *onboardingRepository) CreateStorageAccount(server entity.Server, storageAccountName string) error {
+	client, err := armstorage.NewAccountsClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	poller, err := client.BeginCreate(context.Background(), server.ResourceGroup, storageAccountName, armstorage.AccountCreateParameters{
+		Kind:     to.Ptr(armstorage.KindStorageV2),
+		Location: to.Ptr(server.Region),
+		SKU: &armstorage.SKU{
+			Name: to.Ptr(armstorage.SKUNameStandardLRS),
+		},
+	}, nil)
+	if err != nil {
+		slog.Error("failed to create storage account:", err)
+		return err
+	}
+
+	if _, err := poller.PollUntilDone(context.Background(), nil); err != nil {
+		slog.Error("failed to poll the result:", err)
+		return err
+	}
+
+	slog.Info("Storage account " + storageAccountName + " created in resource group " + server.ResourceGroup)
+
+	return nil
+}
+
+func (o *onboardingRepository) EnsureBlobContainer(server entity.Server, containerName string) (bool, error) {
+	exists, err := o.BlobContainerExists(server, containerName)
+	if err != nil || exists {
+		return false, err
+	}
+
+	client, err := armstorage.NewBlobContainersClient(server.SubscriptionId, o.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return false, err
+	}
+
+	if _, err := client.Create(context.Background(), server.ResourceGroup, server.StorageAccountName, containerName, armstorage.BlobContainer{}, nil); err != nil {
+		slog.Error("failed to create blob container:", err)
+		return false, err
+	}
+
+	slog.Info("Blob container " + containerName + " created in storage account " + server.StorageAccountName)
+
+	return true, nil
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/exp/slog"
+)
+
+// Operations are only useful while a client may still be polling for them.
+const operationTTL = 24 * time.Hour
+
+type operationRepository struct {
+	rdb *redis.Client
+}
+
+func NewOperationRepository(rdb *redis.Client) entity.OperationRepository {
+	return &operationRepository{
+		rdb: rdb,
+	}
+}
+
+func operationKey(id string) string {
+	return "operation:" + id
+}
+
+func (o *operationRepository) UpsertOperation(operation entity.Operation) error {
+	val, err := json.Marshal(operation)
+	if err != nil {
+		slog.Error("error marshalling operation:", err)
+		return fmt.Errorf("error marshalling operation %w", err)
+	}
+
+	if err := o.rdb.Set(operationKey(operation.Id), val, operationTTL).Err(); err != nil {
+		slog.Error("error upserting operation:", err)
+		return fmt.Errorf("error upserting operation %w", err)
+	}
+
+	return nil
+}
+
+func (o *operationRepository) GetOperation(id string) (entity.Operation, error) {
+	val, err := o.rdb.Get(operationKey(id)).Result()
+	if err == redis.Nil {
+		return entity.Operation{}, entity.ErrOperationNotFound
+	}
+	if err != nil {
+		slog.Error("error getting operation:", err)
+		return entity.Operation{}, fmt.Errorf("error getting operation %w", err)
+	}
+
+	operation := entity.Operation{}
+	if err := json.Unmarshal([]byte(val), &operation); err != nil {
+		slog.Error("error unmarshalling operation:", err)
+		return entity.Operation{}, fmt.Errorf("error unmarshalling operation %w", err)
+	}
+
+	return operation, nil
+}
+
+func operationEventsChannel(id string) string {
+	return "operation-events:" + id
+}
+
+func (o *operationRepository) PublishOperationEvent(event entity.OperationEvent) error {
+	val, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("error marshalling operation event:", err)
+		return fmt.Errorf("error marshalling operation event %w", err)
+	}
+
+	if err := o.rdb.Publish(operationEventsChannel(event.OperationId), val).Err(); err != nil {
+		slog.Error("error publishing operation event:", err)
+		return fmt.Errorf("error publishing operation event %w", err)
+	}
+
+	return nil
+}
+
+// SubscribeOperationEvents uses redis pub/sub so that events reach the client no matter
+// which replica is running the operation.
+func (o *operationRepository) SubscribeOperationEvents(id string) (<-chan entity.OperationEvent, func(), error) {
+	pubsub := o.rdb.Subscribe(operationEventsChannel(id))
+
+	// Wait for the subscription to be confirmed so that no events are missed.
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		slog.Error("error subscribing to operation events:", err)
+		return nil, nil, fmt.Errorf("error subscribing to operation events %w", err)
+	}
+
+	events := make(chan entity.OperationEvent)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(events)
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-done:
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				event := entity.OperationEvent{}
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					slog.Error("error unmarshalling operation event:", err)
+					continue
+				}
+				select {
+				case events <- event:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			close(done)
+			pubsub.Close()
+		})
+	}
+
+	return events, unsubscribe, nil
+}
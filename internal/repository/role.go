@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v3"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+func (s *serverRepository) ListManagedIdentityRoleAssignments(server entity.Server) ([]entity.RoleAssignment, error) {
+	roleAssignments := []entity.RoleAssignment{}
+
+	if server.ManagedIdentityPrincipalId == "" {
+		return roleAssignments, errors.New("managed identity principal id is required")
+	}
+
+	clientFactory, err := armauthorization.NewClientFactory(server.SubscriptionId, s.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return roleAssignments, err
+	}
+
+	scope := "/subscriptions/" + server.SubscriptionId
+	filter := "assignedTo('" + server.ManagedIdentityPrincipalId + "')"
+
+	pager := clientFactory.NewRoleAssignmentsClient().NewListForScopePager(scope, &armauthorization.RoleAssignmentsClientListForScopeOptions{
+		Filter: &filter,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			slog.Error("failed to get the next page:", err)
+			return roleAssignments, err
+		}
+		for _, roleAssignment := range page.Value {
+			// assignedTo also returns assignments inherited from management groups and
+			// those on resource groups, only the ones on the subscription itself count.
+			if !strings.EqualFold(*roleAssignment.Properties.Scope, scope) {
+				continue
+			}
+			roleAssignments = append(roleAssignments, entity.RoleAssignment{
+				Id:               *roleAssignment.ID,
+				RoleDefinitionId: roleDefinitionGuid(*roleAssignment.Properties.RoleDefinitionID),
+				Scope:            *roleAssignment.Properties.Scope,
+			})
+		}
+	}
+
+	return roleAssignments, nil
+}
+
+// https://learn.microsoft.com/en-us/azure/role-based-access-control/role-assignments-rest
+func (s *serverRepository) CreateManagedIdentityRoleAssignment(server entity.Server, roleDefinitionId string) error {
+	clientFactory, err := armauthorization.NewClientFactory(server.SubscriptionId, s.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	scope := "/subscriptions/" + server.SubscriptionId
+
+	_, err = clientFactory.NewRoleAssignmentsClient().Create(context.Background(), scope, uuid.NewString(), armauthorization.RoleAssignmentCreateParameters{
+		Properties: &armauthorization.RoleAssignmentProperties{
+			PrincipalID:      to.Ptr(server.ManagedIdentityPrincipalId),
+			RoleDefinitionID: to.Ptr(scope + "/providers/Microsoft.Authorization/roleDefinitions/" + roleDefinitionId),
+			// Setting the principal type lets ARM skip the directory lookup that fails for new identities.
+			PrincipalType: to.Ptr(armauthorization.PrincipalTypeServicePrincipal),
+		},
+	}, nil)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) {
+			if responseErr.StatusCode == http.StatusConflict && responseErr.ErrorCode == "RoleAssignmentExists" {
+				return nil
+			}
+			if responseErr.ErrorCode == "PrincipalNotFound" {
+				return fmt.Errorf("%w: %s", entity.ErrPrincipalNotFound, server.ManagedIdentityPrincipalId)
+			}
+		}
+		slog.Error("failed to create role assignment:", err)
+		return err
+	}
+
+	slog.Info("Role " + roleDefinitionId + " assigned to managed identity " + server.ManagedIdentityPrincipalId)
+
+	return nil
+}
+
+func (s *serverRepository) DeleteManagedIdentityRoleAssignment(server entity.Server, roleAssignmentId string) error {
+	clientFactory, err := armauthorization.NewClientFactory(server.SubscriptionId, s.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	// Deleting an assignment that is already gone returns 204, there is nothing to ignore.
+	if _, err := clientFactory.NewRoleAssignmentsClient().DeleteByID(context.Background(), roleAssignmentId, nil); err != nil {
+		slog.Error("failed to delete role assignment:", err)
+		return err
+	}
+
+	slog.Info("Role assignment " + roleAssignmentId + " deleted")
+
+	return nil
+}
+
+func roleDefinitionGuid(roleDefinitionId string) string {
+	return strings.ToLower(roleDefinitionId[strings.LastIndex(roleDefinitionId, "/")+1:])
+}
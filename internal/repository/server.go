@@ -5,331 +5,158 @@ import (
 	"actlabs-managed-server/internal/config"
 	"actlabs-managed-server/internal/entity"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v3"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerinstance/armcontainerinstance"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/msi/armmsi"
 	"golang.org/x/exp/slog"
 )
 
+// serverRepository hands compute operations to the server's backend, everything else
+// (identity, ownership and the server store) is shared by all backends.
 type serverRepository struct {
 	// https://pkg.go.dev/github.com/Azure/azure-sdk-for-go/sdk/azidentity#DefaultAzureCredential
 	auth      *auth.Auth
 	appConfig *config.Config
+	store     entity.ServerStore
+	backends  map[string]entity.ComputeBackend
 }
 
 func NewServerRepository(
 	appConfig *config.Config,
 	auth *auth.Auth,
+	store entity.ServerStore,
 ) (entity.ServerRepository, error) {
+	containerAppBackend, err := newContainerAppBackend(appConfig, auth)
+	if err != nil {
+		return nil, err
+	}
+
 	return &serverRepository{
 		appConfig: appConfig,
 		auth:      auth,
+		store:     store,
+		backends: map[string]entity.ComputeBackend{
+			entity.BackendAzureContainerInstances: newAciBackend(appConfig, auth),
+			entity.BackendAzureContainerApps:      containerAppBackend,
+		},
 	}, nil
 }
 
-func (s *serverRepository) GetAzureContainerGroup(server entity.Server) (entity.Server, error) {
-	ctx := context.Background()
-	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, s.auth.Cred, nil)
-	if err != nil {
-		slog.Error("failed to create client:", err)
-		return server, err
+// backend picks the compute backend of the server. Requests that don't name one use the
+// backend the server was deployed with, new servers use the configured default.
+func (s *serverRepository) backend(server *entity.Server) (entity.ComputeBackend, error) {
+	if server.Backend == "" {
+		if record, err := s.GetServerFromDatabase("actlabs", server.UserPrincipalName); err == nil {
+			server.Backend = record.Backend
+		}
 	}
 
-	res, err := clientFactory.Get(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
-	if err != nil {
-		slog.Error("failed to finish the request:", err)
-		return server, err
+	if server.Backend == "" {
+		server.Backend = s.appConfig.ComputeBackend
 	}
 
-	server.Endpoint = *res.Properties.IPAddress.Fqdn
-	server.Status = string(*res.Properties.ProvisioningState)
+	backend, ok := s.backends[server.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown compute backend %s", server.Backend)
+	}
 
-	return server, nil
+	return backend, nil
 }
 
-func (s *serverRepository) GetUserAssignedManagedIdentity(server entity.Server) (entity.Server, error) {
-	ctx := context.Background()
-	clientFactory, err := armmsi.NewClientFactory(server.SubscriptionId, s.auth.Cred, nil)
+func (s *serverRepository) GetAzureContainerGroup(server entity.Server) (entity.Server, error) {
+	backend, err := s.backend(&server)
 	if err != nil {
-		slog.Error("failed to create client:", err)
 		return server, err
 	}
+	return backend.Get(server)
+}
 
-	res, err := clientFactory.NewUserAssignedIdentitiesClient().Get(ctx, server.ResourceGroup, server.UserAlias+"-msi", nil)
+func (s *serverRepository) DeployAzureContainerGroup(server entity.Server) (entity.Server, error) {
+	backend, err := s.backend(&server)
 	if err != nil {
-		slog.Error("failed to finish the request:", err)
 		return server, err
 	}
-
-	server.ManagedIdentityClientId = *res.Properties.ClientID
-	server.ManagedIdentityPrincipalId = *res.Properties.PrincipalID
-	server.ManagedIdentityResourceId = *res.ID
-
-	return server, nil
+	return backend.Deploy(server)
 }
 
-func (s *serverRepository) DeployAzureContainerGroup(server entity.Server) (entity.Server, error) {
-
-	ctx := context.Background()
-
-	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, s.auth.Cred, nil)
+func (s *serverRepository) EnsureServerUp(server entity.Server) error {
+	backend, err := s.backend(&server)
 	if err != nil {
-		slog.Error("failed to create client:", err)
-		return server, err
+		return err
 	}
+	return backend.EnsureUp(server)
+}
 
-	poller, err := clientFactory.BeginCreateOrUpdate(ctx,
-		server.ResourceGroup,
-		server.UserAlias+"-aci", armcontainerinstance.ContainerGroup{
-			Location: to.Ptr(server.Region),
-			Identity: &armcontainerinstance.ContainerGroupIdentity{
-				Type: to.Ptr(armcontainerinstance.ResourceIdentityTypeUserAssigned),
-				UserAssignedIdentities: map[string]*armcontainerinstance.Components10Wh5UdSchemasContainergroupidentityPropertiesUserassignedidentitiesAdditionalproperties{
-					server.ManagedIdentityResourceId: {},
-				},
-			},
-			Properties: &armcontainerinstance.ContainerGroupProperties{
-				// https://learn.microsoft.com/en-us/azure/container-instances/container-instances-init-container
-				InitContainers: []*armcontainerinstance.InitContainerDefinition{
-					{
-						Name: to.Ptr("init"),
-						Properties: &armcontainerinstance.InitContainerPropertiesDefinition{
-							Image: to.Ptr("busybox"),
-							EnvironmentVariables: []*armcontainerinstance.EnvironmentVariable{
-								{
-									Name:  to.Ptr("USER_ALIAS"),
-									Value: to.Ptr(server.UserAlias),
-								},
-							},
-							VolumeMounts: []*armcontainerinstance.VolumeMount{
-								{
-									Name:      to.Ptr("emptydir"),
-									MountPath: to.Ptr("/etc/caddy"),
-								},
-							},
-							Command: []*string{
-								to.Ptr("/bin/sh"),
-								to.Ptr("-c"),
-								to.Ptr("echo -e \"${USER_ALIAS}-actlabs-aci.eastus.azurecontainer.io {\n\treverse_proxy http://localhost:8881\n}\" > /etc/caddy/Caddyfile"),
-							},
-						},
-					},
-				},
-				Containers: []*armcontainerinstance.Container{
-					{
-						Name: to.Ptr("caddy"),
-						Properties: &armcontainerinstance.ContainerProperties{
-							Image: to.Ptr("ashishvermapu/caddy:latest"),
-							Ports: []*armcontainerinstance.ContainerPort{
-								{
-									Port:     to.Ptr[int32](s.appConfig.HttpPort),
-									Protocol: to.Ptr(armcontainerinstance.ContainerNetworkProtocolTCP),
-								},
-								{
-									Port:     to.Ptr[int32](s.appConfig.HttpsPort),
-									Protocol: to.Ptr(armcontainerinstance.ContainerNetworkProtocolTCP),
-								},
-							},
-							Resources: &armcontainerinstance.ResourceRequirements{
-								Requests: &armcontainerinstance.ResourceRequests{
-									CPU:        to.Ptr[float64](s.appConfig.CaddyCPU),
-									MemoryInGB: to.Ptr[float64](s.appConfig.CaddyMemory),
-								},
-							},
-							VolumeMounts: []*armcontainerinstance.VolumeMount{
-								{
-									Name:      to.Ptr("emptydir"),
-									MountPath: to.Ptr("/etc/caddy"),
-								},
-							},
-						},
-					},
-					{
-						Name: to.Ptr("actlabs"),
-						Properties: &armcontainerinstance.ContainerProperties{
-							Image: to.Ptr("ashishvermapu/repro:alpha"),
-							Ports: []*armcontainerinstance.ContainerPort{
-								{
-									Port:     to.Ptr[int32](s.appConfig.ActlabsPort),
-									Protocol: to.Ptr(armcontainerinstance.ContainerNetworkProtocolTCP),
-								},
-							},
-							Resources: &armcontainerinstance.ResourceRequirements{
-								Requests: &armcontainerinstance.ResourceRequests{
-									CPU:        to.Ptr[float64](s.appConfig.ActlabsCPU),
-									MemoryInGB: to.Ptr[float64](s.appConfig.ActlabsMemory),
-								},
-							},
-							ReadinessProbe: &armcontainerinstance.ContainerProbe{
-								InitialDelaySeconds: to.Ptr[int32](s.appConfig.ActlabsReadinessProbeInitialDelaySeconds),
-								PeriodSeconds:       to.Ptr[int32](s.appConfig.ActlabsReadinessProbePeriodSeconds),
-								FailureThreshold:    to.Ptr[int32](s.appConfig.ActlabsReadinessProbeFailureThreshold),
-								SuccessThreshold:    to.Ptr[int32](s.appConfig.ActlabsReadinessProbeSuccessThreshold),
-								TimeoutSeconds:      to.Ptr[int32](s.appConfig.ActlabsReadinessProbeTimeoutSeconds),
-								HTTPGet: &armcontainerinstance.ContainerHTTPGet{
-									Path:   to.Ptr(s.appConfig.ReadinessProbePath),
-									Port:   to.Ptr[int32](s.appConfig.ActlabsPort),
-									Scheme: to.Ptr(armcontainerinstance.SchemeHTTP),
-								},
-							},
-							EnvironmentVariables: []*armcontainerinstance.EnvironmentVariable{
-								{
-									Name:  to.Ptr("ARM_USE_MSI"),
-									Value: to.Ptr(strconv.FormatBool(s.appConfig.UseMsi)),
-								},
-								{
-									Name:  to.Ptr("USE_MSI"),
-									Value: to.Ptr(strconv.FormatBool(s.appConfig.UseMsi)),
-								},
-								{
-									Name:  to.Ptr("PROTECTED_LAB_SECRET"),
-									Value: to.Ptr(s.appConfig.ProtectedLabSecret),
-								},
-								{
-									Name:  to.Ptr("ACTLABS_AUTH_URL"),
-									Value: to.Ptr(s.appConfig.ActlabsAuthURL),
-								},
-								{
-									Name:  to.Ptr("PORT"),
-									Value: to.Ptr(strconv.Itoa(int(s.appConfig.ActlabsPort))),
-								},
-								{
-									Name:  to.Ptr("ROOT_DIR"),
-									Value: to.Ptr(s.appConfig.ActlabsRootDir),
-								},
-								{
-									Name:  to.Ptr("AZURE_CLIENT_ID"), // https://github.com/microsoft/azure-container-apps/issues/442
-									Value: &server.ManagedIdentityClientId,
-								},
-								{
-									Name:  to.Ptr("ARM_SUBSCRIPTION_ID"),
-									Value: &server.SubscriptionId,
-								},
-								{
-									Name:  to.Ptr("AZURE_SUBSCRIPTION_ID"),
-									Value: &server.SubscriptionId,
-								},
-								{
-									Name:  to.Ptr("ARM_TENANT_ID"),
-									Value: to.Ptr(s.appConfig.TenantID),
-								},
-								{
-									Name:  to.Ptr("ARM_USER_PRINCIPAL_NAME"),
-									Value: to.Ptr(server.UserPrincipalName),
-								},
-								{
-									Name:  to.Ptr("LOG_LEVEL"),
-									Value: to.Ptr(server.LogLevel),
-								},
-								{
-									Name:  to.Ptr("AUTH_TOKEN_ISS"),
-									Value: to.Ptr(s.appConfig.AuthTokenIss),
-								},
-								{
-									Name:  to.Ptr("AUTH_TOKEN_AUD"),
-									Value: to.Ptr(s.appConfig.AuthTokenAud),
-								},
-							},
-							VolumeMounts: []*armcontainerinstance.VolumeMount{
-								{
-									Name:      to.Ptr("emptydir"),
-									MountPath: to.Ptr("/mnt/emptydir"),
-								},
-							},
-						},
-					},
-				},
-				OSType:        to.Ptr(armcontainerinstance.OperatingSystemTypesLinux),
-				RestartPolicy: to.Ptr(armcontainerinstance.ContainerGroupRestartPolicyAlways),
-				IPAddress: &armcontainerinstance.IPAddress{
-					Ports: []*armcontainerinstance.Port{
-						{
-							Port:     to.Ptr[int32](s.appConfig.HttpPort),
-							Protocol: to.Ptr(armcontainerinstance.ContainerGroupNetworkProtocolTCP),
-						},
-						{
-							Port:     to.Ptr[int32](s.appConfig.HttpsPort),
-							Protocol: to.Ptr(armcontainerinstance.ContainerGroupNetworkProtocolTCP),
-						},
-					},
-					Type:         to.Ptr(armcontainerinstance.ContainerGroupIPAddressTypePublic),
-					DNSNameLabel: to.Ptr(server.UserAlias + "-actlabs-aci"),
-				},
-				Volumes: []*armcontainerinstance.Volume{
-					{
-						Name:     to.Ptr("emptydir"),
-						EmptyDir: &struct{}{},
-					},
-				},
-			},
-		}, nil)
+func (s *serverRepository) GetContainerLogs(server entity.Server, containerName string, tail int, timestamps bool) (string, error) {
+	backend, err := s.backend(&server)
 	if err != nil {
-		slog.Error("failed to finish the request:", err)
-		return server, err
+		return "", err
 	}
+	return backend.GetLogs(server, containerName, tail, timestamps)
+}
 
-	resp, err := poller.PollUntilDone(ctx, nil)
+func (s *serverRepository) ExecuteContainerCommand(server entity.Server, request entity.TerminalRequest) (entity.ContainerExec, error) {
+	backend, err := s.backend(&server)
 	if err != nil {
-		slog.Error("failed to pull the result:", err)
-		return server, err
+		return entity.ContainerExec{}, err
 	}
-
-	server.Endpoint = *resp.Properties.IPAddress.Fqdn
-	server.Status = *resp.Properties.ProvisioningState
-
-	return server, nil
+	return backend.ExecuteCommand(server, request)
 }
 
-func (s *serverRepository) EnsureServerUp(server entity.Server) error {
-	// Call the server endpoint to check if it is up
-	serverEndpoint := "https://" + server.Endpoint + s.appConfig.ReadinessProbePath
-	slog.Info("Checking if server is up: " + serverEndpoint)
-
-	resp, err := http.Get(serverEndpoint)
+func (s *serverRepository) DestroyAzureContainerGroup(server entity.Server) error {
+	backend, err := s.backend(&server)
 	if err != nil {
-		slog.Error("Failed to make HTTP request:", err)
 		return err
 	}
-	defer resp.Body.Close()
+	return backend.Destroy(server)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		slog.Error("Server is not up. Status code:", resp.StatusCode)
-		return errors.New("server is not up")
+func (s *serverRepository) StopAzureContainerGroup(server entity.Server) error {
+	backend, err := s.backend(&server)
+	if err != nil {
+		return err
 	}
+	return backend.Stop(server)
+}
 
-	return nil
+func (s *serverRepository) StartAzureContainerGroup(server entity.Server) error {
+	backend, err := s.backend(&server)
+	if err != nil {
+		return err
+	}
+	return backend.Start(server)
 }
 
-func (s *serverRepository) DestroyAzureContainerGroup(server entity.Server) error {
+func (s *serverRepository) RestartAzureContainerGroup(server entity.Server) error {
+	backend, err := s.backend(&server)
+	if err != nil {
+		return err
+	}
+	return backend.Restart(server)
+}
 
+func (s *serverRepository) GetUserAssignedManagedIdentity(server entity.Server) (entity.Server, error) {
 	ctx := context.Background()
-
-	clientFactory, err := armcontainerinstance.NewContainerGroupsClient(server.SubscriptionId, s.auth.Cred, nil)
+	clientFactory, err := armmsi.NewClientFactory(server.SubscriptionId, s.auth.Cred, nil)
 	if err != nil {
 		slog.Error("failed to create client:", err)
-		return err
+		return server, err
 	}
 
-	poller, err := clientFactory.BeginDelete(ctx, server.ResourceGroup, server.UserAlias+"-aci", nil)
+	res, err := clientFactory.NewUserAssignedIdentitiesClient().Get(ctx, server.ResourceGroup, server.UserAlias+"-msi", nil)
 	if err != nil {
 		slog.Error("failed to finish the request:", err)
-		return err
+		return server, err
 	}
 
-	_, err = poller.PollUntilDone(ctx, nil)
-	if err != nil {
-		slog.Error("failed to pull the result:", err)
-		return err
-	}
+	server.ManagedIdentityClientId = *res.Properties.ClientID
+	server.ManagedIdentityPrincipalId = *res.Properties.PrincipalID
+	server.ManagedIdentityResourceId = *res.ID
 
-	return nil
+	return server, nil
 }
 
 // https://learn.microsoft.com/en-us/rest/api/managedidentity/user-assigned-identities/create-or-update?view=rest-managedidentity-2023-01-31&tabs=Go
@@ -360,6 +187,23 @@ func (s *serverRepository) CreateUserAssignedManagedIdentity(server entity.Serve
 	return server, nil
 }
 
+func (s *serverRepository) DeleteUserAssignedManagedIdentity(server entity.Server) error {
+	clientFactory, err := armmsi.NewClientFactory(server.SubscriptionId, s.auth.Cred, nil)
+	if err != nil {
+		slog.Error("failed to create client:", err)
+		return err
+	}
+
+	if _, err := clientFactory.NewUserAssignedIdentitiesClient().Delete(context.Background(), server.ResourceGroup, server.UserAlias+"-msi", nil); err != nil {
+		slog.Error("failed to finish the request:", err)
+		return err
+	}
+
+	slog.Info("Managed Identity " + server.UserAlias + "-msi deleted")
+
+	return nil
+}
+
 // verify that user is the owner of the subscription
 func (s *serverRepository) IsUserOwner(server entity.Server) (bool, error) {
 	slog.Info("Checking if user " + server.UserAlias + " is owner of the subscription " + server.SubscriptionId)
@@ -409,36 +253,23 @@ func (s *serverRepository) UpsertServerInDatabase(server entity.Server) error {
 	server.PartitionKey = "actlabs"
 	server.RowKey = server.UserPrincipalName
 
-	val, err := json.Marshal(server)
-	if err != nil {
-		slog.Error("error marshalling server:", err)
-		return fmt.Errorf("error marshalling server %w", err)
-	}
-
-	_, err = s.auth.ActlabsServersTableClient.UpsertEntity(context.Background(), val, nil)
-	if err != nil {
-		slog.Error("error upserting server:", err)
-		return fmt.Errorf("error upserting server %w", err)
+	if err := s.store.UpsertServer(server); err != nil {
+		return err
 	}
 
 	slog.Debug("Server upserted in database")
 
 	return nil
 }
-func (s *serverRepository) GetServerFromDatabase(partitionKey string, rowKey string) (entity.Server, error) {
-	response, err := s.auth.ActlabsServersTableClient.GetEntity(context.Background(), partitionKey, rowKey, nil)
-	if err != nil {
-		slog.Error("error getting server from database:", err)
-		return entity.Server{}, fmt.Errorf("error getting server from database %w", err)
-	}
 
-	server := entity.Server{}
-	err = json.Unmarshal(response.Value, &server)
-	if err != nil {
-		slog.Error("error unmarshalling server:", err)
-		return entity.Server{}, fmt.Errorf("error unmarshalling server %w", err)
-	}
+func (s *serverRepository) GetServerFromDatabase(partitionKey string, rowKey string) (entity.Server, error) {
+	return s.store.GetServer(partitionKey, rowKey)
+}
 
-	return server, nil
+func (s *serverRepository) ListServersFromDatabase(query entity.ServerQuery) ([]entity.Server, error) {
+	return s.store.ListServers(query)
+}
 
+func (s *serverRepository) DeleteServerFromDatabase(partitionKey string, rowKey string) error {
+	return s.store.DeleteServer(partitionKey, rowKey)
 }
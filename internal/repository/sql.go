@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/exp/slog"
+)
+
+// The server is stored as json like in the table store. The columns that servers are
+// queried by are kept next to it.
+const sqlServersSchema = `CREATE TABLE IF NOT EXISTS servers (
+	partition_key TEXT NOT NULL,
+	row_key TEXT NOT NULL,
+	subscription_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	backend TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (partition_key, row_key)
+)`
+
+// sqlServerStore keeps the servers in SQLite or PostgreSQL.
+type sqlServerStore struct {
+	db     *sql.DB
+	driver string
+}
+
+func newSqlServerStore(store string, dsn string) (entity.ServerStore, error) {
+	db, driver, err := openSqlDB(store, dsn, sqlServersSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlServerStore{
+		db:     db,
+		driver: driver,
+	}, nil
+}
+
+// openSqlDB opens the database of the store and creates the table of the schema.
+func openSqlDB(store string, dsn string, schema string) (*sql.DB, string, error) {
+	driver := store
+	if store == ServerStoreSqlite {
+		driver = "sqlite3"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, "", fmt.Errorf("not able to open %s server store %w", store, err)
+	}
+
+	if store == ServerStoreSqlite {
+		// SQLite allows a single writer, concurrent writes would fail with database is locked.
+		db.SetMaxOpenConns(1)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, "", fmt.Errorf("not able to create table %w", err)
+	}
+
+	return db, driver, nil
+}
+
+func (s *sqlServerStore) rebind(query string) string {
+	return rebind(s.driver, query)
+}
+
+// rebind turns the ? placeholders into the $n placeholders postgres expects.
+func rebind(driver string, query string) string {
+	if driver != ServerStorePostgres {
+		return query
+	}
+
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+func (s *sqlServerStore) UpsertServer(server entity.Server) error {
+	val, err := json.Marshal(server)
+	if err != nil {
+		slog.Error("error marshalling server:", err)
+		return fmt.Errorf("error marshalling server %w", err)
+	}
+
+	_, err = s.db.Exec(s.rebind(`INSERT INTO servers (partition_key, row_key, subscription_id, status, backend, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (partition_key, row_key) DO UPDATE SET
+			subscription_id = excluded.subscription_id,
+			status = excluded.status,
+			backend = excluded.backend,
+			data = excluded.data`),
+		server.PartitionKey, server.RowKey, server.SubscriptionId, server.Status, server.Backend, string(val),
+	)
+	if err != nil {
+		slog.Error("error upserting server:", err)
+		return fmt.Errorf("error upserting server %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqlServerStore) GetServer(partitionKey string, rowKey string) (entity.Server, error) {
+	var data string
+	err := s.db.QueryRow(s.rebind(`SELECT data FROM servers WHERE partition_key = ? AND row_key = ?`), partitionKey, rowKey).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Server{}, entity.ErrServerNotFound
+	}
+	if err != nil {
+		slog.Error("error getting server from database:", err)
+		return entity.Server{}, fmt.Errorf("error getting server from database %w", err)
+	}
+
+	server := entity.Server{}
+	if err := json.Unmarshal([]byte(data), &server); err != nil {
+		slog.Error("error unmarshalling server:", err)
+		return entity.Server{}, fmt.Errorf("error unmarshalling server %w", err)
+	}
+
+	return server, nil
+}
+
+func (s *sqlServerStore) ListServers(query entity.ServerQuery) ([]entity.Server, error) {
+	servers := []entity.Server{}
+
+	statement := `SELECT data FROM servers WHERE partition_key = ?`
+	args := []any{"actlabs"}
+	for column, value := range map[string]string{
+		"subscription_id": query.SubscriptionId,
+		"status":          query.Status,
+		"backend":         query.Backend,
+	} {
+		if value != "" {
+			statement += " AND " + column + " = ?"
+			args = append(args, value)
+		}
+	}
+
+	rows, err := s.db.Query(s.rebind(statement), args...)
+	if err != nil {
+		slog.Error("error listing servers from database:", err)
+		return servers, fmt.Errorf("error listing servers from database %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return servers, fmt.Errorf("error listing servers from database %w", err)
+		}
+
+		server := entity.Server{}
+		if err := json.Unmarshal([]byte(data), &server); err != nil {
+			slog.Error("error unmarshalling server:", err)
+			return servers, fmt.Errorf("error unmarshalling server %w", err)
+		}
+		servers = append(servers, server)
+	}
+
+	if err := rows.Err(); err != nil {
+		return servers, fmt.Errorf("error listing servers from database %w", err)
+	}
+
+	return servers, nil
+}
+
+func (s *sqlServerStore) DeleteServer(partitionKey string, rowKey string) error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM servers WHERE partition_key = ? AND row_key = ?`), partitionKey, rowKey)
+	if err != nil {
+		slog.Error("error deleting server from database:", err)
+		return fmt.Errorf("error deleting server from database %w", err)
+	}
+
+	return nil
+}
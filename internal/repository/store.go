@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Server stores that can be selected with SERVER_STORE.
+const (
+	ServerStoreTable    = "table"
+	ServerStoreSqlite   = "sqlite"
+	ServerStorePostgres = "postgres"
+)
+
+// NewServerStore returns the configured server store. The credential is only used by the
+// table store and may be nil otherwise.
+func NewServerStore(appConfig *config.Config, cred azcore.TokenCredential) (entity.ServerStore, error) {
+	switch appConfig.ServerStore {
+	case ServerStoreTable:
+		return newTableServerStore(appConfig, cred)
+	case ServerStoreSqlite, ServerStorePostgres:
+		return newSqlServerStore(appConfig.ServerStore, appConfig.ServerStoreDSN)
+	default:
+		return nil, fmt.Errorf("unknown server store %s", appConfig.ServerStore)
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/auth"
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+	"golang.org/x/exp/slog"
+)
+
+// tableServerStore keeps the servers in the Azure Storage table of the actlabs storage account.
+type tableServerStore struct {
+	client *aztables.Client
+}
+
+func newTableServerStore(appConfig *config.Config, cred azcore.TokenCredential) (entity.ServerStore, error) {
+	if cred == nil {
+		return nil, errors.New("table server store needs azure credentials")
+	}
+
+	client, err := auth.GetTableClient(
+		appConfig.ActlabsSubscriptionID,
+		cred,
+		appConfig.ActlabsResourceGroup,
+		appConfig.ActlabsStorageAccount,
+		appConfig.ActlabsServerTableName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("not able to create table client %w", err)
+	}
+
+	return &tableServerStore{
+		client: client,
+	}, nil
+}
+
+func (t *tableServerStore) UpsertServer(server entity.Server) error {
+	val, err := json.Marshal(server)
+	if err != nil {
+		slog.Error("error marshalling server:", err)
+		return fmt.Errorf("error marshalling server %w", err)
+	}
+
+	_, err = t.client.UpsertEntity(context.Background(), val, nil)
+	if err != nil {
+		slog.Error("error upserting server:", err)
+		return fmt.Errorf("error upserting server %w", err)
+	}
+
+	return nil
+}
+
+func (t *tableServerStore) GetServer(partitionKey string, rowKey string) (entity.Server, error) {
+	response, err := t.client.GetEntity(context.Background(), partitionKey, rowKey, nil)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+			return entity.Server{}, entity.ErrServerNotFound
+		}
+		slog.Error("error getting server from database:", err)
+		return entity.Server{}, fmt.Errorf("error getting server from database %w", err)
+	}
+
+	server := entity.Server{}
+	err = json.Unmarshal(response.Value, &server)
+	if err != nil {
+		slog.Error("error unmarshalling server:", err)
+		return entity.Server{}, fmt.Errorf("error unmarshalling server %w", err)
+	}
+
+	return server, nil
+}
+
+func (t *tableServerStore) ListServers(query entity.ServerQuery) ([]entity.Server, error) {
+	servers := []entity.Server{}
+
+	pager := t.client.NewListEntitiesPager(&aztables.ListEntitiesOptions{
+		Filter: to.Ptr(tableFilter(query)),
+	})
+	for pager.More() {
+		response, err := pager.NextPage(context.Background())
+		if err != nil {
+			slog.Error("error listing servers from database:", err)
+			return servers, fmt.Errorf("error listing servers from database %w", err)
+		}
+
+		for _, value := range response.Entities {
+			server := entity.Server{}
+			if err := json.Unmarshal(value, &server); err != nil {
+				slog.Error("error unmarshalling server:", err)
+				return servers, fmt.Errorf("error unmarshalling server %w", err)
+			}
+			servers = append(servers, server)
+		}
+	}
+
+	return servers, nil
+}
+
+func (t *tableServerStore) DeleteServer(partitionKey string, rowKey string) error {
+	_, err := t.client.DeleteEntity(context.Background(), partitionKey, rowKey, nil)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		slog.Error("error deleting server from database:", err)
+		return fmt.Errorf("error deleting server from database %w", err)
+	}
+
+	return nil
+}
+
+// tableFilter builds the OData filter of the query. Properties are named after the json
+// fields of the server.
+func tableFilter(query entity.ServerQuery) string {
+	filters := []string{"PartitionKey eq 'actlabs'"}
+
+	for property, value := range map[string]string{
+		"subscriptionId": query.SubscriptionId,
+		"status":         query.Status,
+		"backend":        query.Backend,
+	} {
+		if value != "" {
+			filters = append(filters, property+" eq '"+escapeODataString(value)+"'")
+		}
+	}
+
+	return strings.Join(filters, " and ")
+}
+
+// escapeODataString quotes a value for a string literal in an OData filter.
+func escapeODataString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	terminalSessionsKey     = "audit:terminal-sessions"
+	terminalSessionsMaxKept = 10000
+)
+
+type terminalRepository struct {
+	rdb *redis.Client
+}
+
+func NewTerminalRepository(rdb *redis.Client) entity.TerminalRepository {
+	return &terminalRepository{
+		rdb: rdb,
+	}
+}
+
+// RecordTerminalSession appends the session to the audit trail. A session is recorded
+// once when it starts and once when it ends.
+func (t *terminalRepository) RecordTerminalSession(session entity.TerminalSession) error {
+	val, err := json.Marshal(session)
+	if err != nil {
+		slog.Error("error marshalling terminal session:", err)
+		return fmt.Errorf("error marshalling terminal session %w", err)
+	}
+
+	pipe := t.rdb.TxPipeline()
+	pipe.LPush(terminalSessionsKey, val)
+	pipe.LTrim(terminalSessionsKey, 0, terminalSessionsMaxKept-1)
+	if _, err := pipe.Exec(); err != nil {
+		slog.Error("error recording terminal session:", err)
+		return fmt.Errorf("error recording terminal session %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"actlabs-managed-server/internal/auth"
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+	"golang.org/x/exp/slog"
+)
+
+const sqlServerUsageSchema = `CREATE TABLE IF NOT EXISTS server_usage (
+	partition_key TEXT NOT NULL,
+	row_key TEXT NOT NULL,
+	start_time TEXT NOT NULL,
+	end_time TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (partition_key, row_key)
+)`
+
+// NewUsageStore returns the usage store next to the configured server store. The
+// credential is only used by the table store and may be nil otherwise.
+func NewUsageStore(appConfig *config.Config, cred azcore.TokenCredential) (entity.UsageRepository, error) {
+	switch appConfig.ServerStore {
+	case ServerStoreTable:
+		return newTableUsageStore(appConfig, cred)
+	case ServerStoreSqlite, ServerStorePostgres:
+		db, driver, err := openSqlDB(appConfig.ServerStore, appConfig.ServerStoreDSN, sqlServerUsageSchema)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlUsageStore{db: db, driver: driver}, nil
+	default:
+		return nil, fmt.Errorf("unknown server store %s", appConfig.ServerStore)
+	}
+}
+
+// tableUsageStore keeps the usage intervals in a table of the actlabs storage account.
+type tableUsageStore struct {
+	client *aztables.Client
+}
+
+func newTableUsageStore(appConfig *config.Config, cred azcore.TokenCredential) (entity.UsageRepository, error) {
+	if cred == nil {
+		return nil, errors.New("table usage store needs azure credentials")
+	}
+
+	client, err := auth.GetTableClient(
+		appConfig.ActlabsSubscriptionID,
+		cred,
+		appConfig.ActlabsResourceGroup,
+		appConfig.ActlabsStorageAccount,
+		appConfig.ActlabsServerUsageTableName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("not able to create table client %w", err)
+	}
+
+	if _, err := client.CreateTable(context.Background(), nil); err != nil {
+		var responseErr *azcore.ResponseError
+		if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusConflict {
+			return nil, fmt.Errorf("not able to create usage table %w", err)
+		}
+	}
+
+	return &tableUsageStore{
+		client: client,
+	}, nil
+}
+
+func (t *tableUsageStore) UpsertUsageInterval(interval entity.UsageInterval) error {
+	val, err := json.Marshal(interval)
+	if err != nil {
+		slog.Error("error marshalling usage interval:", err)
+		return fmt.Errorf("error marshalling usage interval %w", err)
+	}
+
+	if _, err := t.client.UpsertEntity(context.Background(), val, nil); err != nil {
+		slog.Error("error upserting usage interval:", err)
+		return fmt.Errorf("error upserting usage interval %w", err)
+	}
+
+	return nil
+}
+
+func (t *tableUsageStore) ListUsageIntervals(query entity.UsageQuery) ([]entity.UsageInterval, error) {
+	filter := "end eq ''"
+	if !query.OpenOnly {
+		filter = "start lt '" + query.To.UTC().Format(entity.UsageTimeFormat) + "'" +
+			" and (end eq '' or end gt '" + query.From.UTC().Format(entity.UsageTimeFormat) + "')"
+	}
+	if query.UserPrincipalName != "" {
+		filter = "PartitionKey eq '" + escapeODataString(query.UserPrincipalName) + "' and " + filter
+	}
+
+	pager := t.client.NewListEntitiesPager(&aztables.ListEntitiesOptions{
+		Filter: to.Ptr(filter),
+	})
+
+	intervals := []entity.UsageInterval{}
+	for pager.More() {
+		response, err := pager.NextPage(context.Background())
+		if err != nil {
+			slog.Error("error listing usage intervals from database:", err)
+			return nil, fmt.Errorf("error listing usage intervals from database %w", err)
+		}
+
+		for _, value := range response.Entities {
+			interval := entity.UsageInterval{}
+			if err := json.Unmarshal(value, &interval); err != nil {
+				slog.Error("error unmarshalling usage interval:", err)
+				return nil, fmt.Errorf("error unmarshalling usage interval %w", err)
+			}
+			intervals = append(intervals, interval)
+		}
+	}
+
+	return intervals, nil
+}
+
+// sqlUsageStore keeps the usage intervals in the SQLite or PostgreSQL database of the
+// server store.
+type sqlUsageStore struct {
+	db     *sql.DB
+	driver string
+}
+
+func (s *sqlUsageStore) UpsertUsageInterval(interval entity.UsageInterval) error {
+	val, err := json.Marshal(interval)
+	if err != nil {
+		slog.Error("error marshalling usage interval:", err)
+		return fmt.Errorf("error marshalling usage interval %w", err)
+	}
+
+	_, err = s.db.Exec(rebind(s.driver, `INSERT INTO server_usage (partition_key, row_key, start_time, end_time, data)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (partition_key, row_key) DO UPDATE SET
+			start_time = excluded.start_time,
+			end_time = excluded.end_time,
+			data = excluded.data`),
+		interval.PartitionKey, interval.RowKey, interval.Start, interval.End, string(val),
+	)
+	if err != nil {
+		slog.Error("error upserting usage interval:", err)
+		return fmt.Errorf("error upserting usage interval %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqlUsageStore) ListUsageIntervals(query entity.UsageQuery) ([]entity.UsageInterval, error) {
+	where := "end_time = ''"
+	args := []interface{}{}
+	if !query.OpenOnly {
+		where = "start_time < ? AND (end_time = '' OR end_time > ?)"
+		args = append(args, query.To.UTC().Format(entity.UsageTimeFormat), query.From.UTC().Format(entity.UsageTimeFormat))
+	}
+	if query.UserPrincipalName != "" {
+		where = "partition_key = ? AND " + where
+		args = append([]interface{}{query.UserPrincipalName}, args...)
+	}
+
+	rows, err := s.db.Query(rebind(s.driver, `SELECT data FROM server_usage WHERE `+where+` ORDER BY partition_key, row_key`), args...)
+	if err != nil {
+		slog.Error("error listing usage intervals from database:", err)
+		return nil, fmt.Errorf("error listing usage intervals from database %w", err)
+	}
+	defer rows.Close()
+
+	intervals := []entity.UsageInterval{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("error listing usage intervals from database %w", err)
+		}
+
+		interval := entity.UsageInterval{}
+		if err := json.Unmarshal([]byte(data), &interval); err != nil {
+			slog.Error("error unmarshalling usage interval:", err)
+			return nil, fmt.Errorf("error unmarshalling usage interval %w", err)
+		}
+		intervals = append(intervals, interval)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing usage intervals from database %w", err)
+	}
+
+	return intervals, nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+type adminService struct {
+	serverRepository entity.ServerRepository
+	// Reused for the lifecycle, locks and defaults so that admin changes follow the same
+	// rules as the user's, without the ownership check.
+	servers   *serverService
+	appConfig *config.Config
+}
+
+func NewAdminService(
+	serverRepository entity.ServerRepository,
+	operationRepository entity.OperationRepository,
+	eventRepository entity.EventRepository,
+	usageRepository entity.UsageRepository,
+	locker entity.Locker,
+	appConfig *config.Config,
+) entity.AdminService {
+	return &adminService{
+		serverRepository: serverRepository,
+		servers: &serverService{
+			serverAccess: serverAccess{
+				serverRepository: serverRepository,
+				appConfig:        appConfig,
+			},
+			operationRepository: operationRepository,
+			eventRepository:     eventRepository,
+			lifecycle: lifecycle{
+				serverRepository: serverRepository,
+				eventRepository:  eventRepository,
+				usageRepository:  usageRepository,
+				appConfig:        appConfig,
+			},
+			locks: serverLocks{
+				locker: locker,
+				ttl:    time.Duration(appConfig.ServerLockTTLSeconds) * time.Second,
+			},
+		},
+		appConfig: appConfig,
+	}
+}
+
+func (a *adminService) ListServers(caller entity.Caller, query entity.AdminServerQuery) ([]entity.Server, error) {
+	if !caller.Can(entity.PermissionServersRead) {
+		return nil, entity.ErrForbidden
+	}
+
+	servers, err := a.serverRepository.ListServersFromDatabase(entity.ServerQuery{Status: query.Status})
+	a.audit(caller, "list servers", "", err)
+	if err != nil {
+		return nil, err
+	}
+
+	region := helper.NormalizeRegion(query.Region)
+
+	filtered := []entity.Server{}
+	for _, server := range servers {
+		if region != "" && helper.NormalizeRegion(server.Region) != region {
+			continue
+		}
+		if query.IdleMinutes > 0 {
+			// Servers that never reported activity can't be told to be idle.
+			lastActivity, err := time.Parse(time.RFC3339, server.LastUserActivityTime)
+			if err != nil || time.Since(lastActivity) < time.Duration(query.IdleMinutes)*time.Minute {
+				continue
+			}
+		}
+		filtered = append(filtered, server)
+	}
+
+	return filtered, nil
+}
+
+func (a *adminService) GetServer(caller entity.Caller, userPrincipalName string) (entity.Server, error) {
+	if !caller.Can(entity.PermissionServersRead) {
+		return entity.Server{}, entity.ErrForbidden
+	}
+
+	server, err := a.serverRepository.GetServerFromDatabase("actlabs", userPrincipalName)
+	if err != nil {
+		a.audit(caller, "get server", userPrincipalName, err)
+		return entity.Server{}, err
+	}
+
+	a.servers.ServerDefaults(&server)
+
+	live, err := a.servers.liveServer(server)
+	a.audit(caller, "get server", userPrincipalName, err)
+	if err != nil {
+		return entity.Server{}, err
+	}
+
+	return live, nil
+}
+
+func (a *adminService) DestroyServer(caller entity.Caller, userPrincipalName string) (entity.Server, error) {
+	if !caller.Can(entity.PermissionServersWrite) {
+		return entity.Server{}, entity.ErrForbidden
+	}
+
+	server, err := a.serverRepository.GetServerFromDatabase("actlabs", userPrincipalName)
+	if err != nil {
+		a.audit(caller, "destroy server", userPrincipalName, err)
+		return entity.Server{}, err
+	}
+
+	a.servers.ServerDefaults(&server)
+
+	err = a.servers.destroyServer(caller, server, "destroyed by admin")
+	a.audit(caller, "destroy server", userPrincipalName, err)
+	if err != nil {
+		return entity.Server{}, err
+	}
+
+	return a.serverRepository.GetServerFromDatabase("actlabs", userPrincipalName)
+}
+
+func (a *adminService) ResetServer(caller entity.Caller, userPrincipalName string) (entity.Server, error) {
+	if !caller.Can(entity.PermissionServersWrite) {
+		return entity.Server{}, entity.ErrForbidden
+	}
+
+	server, err := a.serverRepository.GetServerFromDatabase("actlabs", userPrincipalName)
+	if err != nil {
+		a.audit(caller, "reset server", userPrincipalName, err)
+		return entity.Server{}, err
+	}
+
+	err = a.servers.locks.release(server)
+	if err == nil {
+		err = a.servers.lifecycle.reset(caller, &server, "reset by admin")
+	}
+	a.audit(caller, "reset server", userPrincipalName, err)
+	if err != nil {
+		return entity.Server{}, err
+	}
+
+	return server, nil
+}
+
+// audit logs the admin action and, when it is on a server, appends it to the server's
+// history.
+func (a *adminService) audit(caller entity.Caller, action string, userPrincipalName string, err error) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed: " + err.Error()
+	}
+
+	slog.Info("admin action",
+		slog.String("action", action),
+		slog.String("userPrincipalName", userPrincipalName),
+		slog.String("result", result),
+		slog.String("callerPrincipalId", caller.PrincipalId),
+		slog.String("callerIp", caller.IP),
+		slog.String("requestId", caller.RequestId),
+	)
+
+	if userPrincipalName == "" {
+		return
+	}
+
+	event := entity.NewServerEvent(userPrincipalName, entity.EventActionAdmin, caller)
+	event.Reason = action + " " + result
+	a.servers.lifecycle.record(event)
+}
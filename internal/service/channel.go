@@ -0,0 +1,128 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/exp/slog"
+)
+
+type releaseChannelService struct {
+	releaseChannelRepository entity.ReleaseChannelRepository
+	serverRepository         entity.ServerRepository
+	appConfig                *config.Config
+}
+
+func NewReleaseChannelService(
+	releaseChannelRepository entity.ReleaseChannelRepository,
+	serverRepository entity.ServerRepository,
+	appConfig *config.Config,
+) entity.ReleaseChannelService {
+	return &releaseChannelService{
+		releaseChannelRepository: releaseChannelRepository,
+		serverRepository:         serverRepository,
+		appConfig:                appConfig,
+	}
+}
+
+// ListReleaseChannels returns the configured channels, sorted by name, with the images
+// admins set in place of the ones from config.
+func (r *releaseChannelService) ListReleaseChannels() ([]entity.ReleaseChannel, error) {
+	images, err := r.releaseChannelRepository.GetReleaseChannelImages()
+	if err != nil {
+		return nil, err
+	}
+
+	channels := []entity.ReleaseChannel{}
+	for name, image := range r.appConfig.ReleaseChannels {
+		if override, ok := images[name]; ok {
+			image = override
+		}
+		channels = append(channels, entity.ReleaseChannel{Name: name, Image: image})
+	}
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+
+	return channels, nil
+}
+
+func (r *releaseChannelService) ListReleaseChannelUsers(caller entity.Caller) ([]entity.ReleaseChannel, error) {
+	if !caller.Can(entity.PermissionServersRead) {
+		return nil, entity.ErrForbidden
+	}
+
+	channels, err := r.ListReleaseChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := r.serverRepository.ListServersFromDatabase(entity.ServerQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string][]string{}
+	for _, server := range servers {
+		// Servers deployed before channels existed are on the default channel.
+		channel := server.ImageChannel
+		if channel == "" {
+			channel = r.appConfig.DefaultReleaseChannel
+		}
+		users[channel] = append(users[channel], server.UserPrincipalName)
+	}
+
+	for i := range channels {
+		channels[i].Users = users[channels[i].Name]
+		sort.Strings(channels[i].Users)
+	}
+
+	return channels, nil
+}
+
+// SetReleaseChannelImage changes the image of a channel. Servers pick it up the next
+// time they are deployed.
+func (r *releaseChannelService) SetReleaseChannelImage(caller entity.Caller, name string, image string) (entity.ReleaseChannel, error) {
+	if !caller.Can(entity.PermissionChannelsWrite) {
+		return entity.ReleaseChannel{}, entity.ErrForbidden
+	}
+
+	if _, ok := r.appConfig.ReleaseChannels[name]; !ok {
+		return entity.ReleaseChannel{}, fmt.Errorf("%w: %s", entity.ErrReleaseChannelNotFound, name)
+	}
+
+	if image == "" {
+		return entity.ReleaseChannel{}, errors.New("image is required")
+	}
+
+	if err := r.releaseChannelRepository.SetReleaseChannelImage(name, image); err != nil {
+		return entity.ReleaseChannel{}, err
+	}
+
+	slog.Info("release channel image changed",
+		slog.String("channel", name),
+		slog.String("image", image),
+		slog.String("callerPrincipalId", caller.PrincipalId),
+		slog.String("requestId", caller.RequestId),
+	)
+
+	return entity.ReleaseChannel{Name: name, Image: image}, nil
+}
+
+// releaseChannelImage returns the image of the channel.
+func (r *releaseChannelService) releaseChannelImage(name string) (string, error) {
+	channels, err := r.ListReleaseChannels()
+	if err != nil {
+		return "", err
+	}
+
+	for _, channel := range channels {
+		if channel.Name == name {
+			return channel.Image, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", entity.ErrReleaseChannelNotFound, name)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Servers stuck in a transient state for longer than this, e.g. because the replica that
+// ran the operation went away, are treated as failed so that they can be deployed or
+// destroyed again.
+const staleTransitionTimeout = time.Hour
+
+// lifecycle moves servers between states and records every move in the server's history
+// and the time it ran in the usage.
+type lifecycle struct {
+	serverRepository entity.ServerRepository
+	eventRepository  entity.EventRepository
+	usageRepository  entity.UsageRepository
+	appConfig        *config.Config
+}
+
+// transition moves the server to the given state and persists the whole server with the
+// time and reason of the move. The current state is read from the stored record, the
+// status in a request is not trusted.
+func (l lifecycle) transition(caller entity.Caller, server *entity.Server, status string, reason string) error {
+	from := ""
+	record, err := l.serverRepository.GetServerFromDatabase("actlabs", server.UserPrincipalName)
+	if err != nil && !errors.Is(err, entity.ErrServerNotFound) {
+		return err
+	}
+	if err == nil {
+		from = record.Status
+	}
+
+	if entity.IsTransientServerStatus(from) && transitionIsStale(record.StatusTime) {
+		slog.Warn("server stuck in transient state, treating it as failed",
+			slog.String("userPrincipalName", server.UserPrincipalName),
+			slog.String("status", from),
+			slog.String("statusTime", record.StatusTime),
+		)
+		from = entity.ServerStatusFailed
+	}
+
+	if !entity.CanTransition(from, status) {
+		if from == "" {
+			from = "not deployed"
+		}
+		return fmt.Errorf("%w: server is %s, it can't move to %s", entity.ErrInvalidTransition, from, status)
+	}
+
+	server.Status = status
+	server.StatusReason = reason
+	server.StatusTime = helper.GetTodaysDateTimeISOString()
+
+	slog.Info("server transition",
+		slog.String("userPrincipalName", server.UserPrincipalName),
+		slog.String("from", from),
+		slog.String("to", status),
+		slog.String("reason", reason),
+	)
+
+	if err := l.serverRepository.UpsertServerInDatabase(*server); err != nil {
+		slog.Error("not able to update server in database", err)
+		return err
+	}
+
+	event := entity.NewServerEvent(server.UserPrincipalName, entity.EventActionTransition, caller)
+	event.FromStatus = from
+	event.ToStatus = status
+	event.Reason = reason
+	l.record(event)
+
+	l.meter(*server)
+
+	return nil
+}
+
+// reset moves the server to failed whatever state it is in. It is how admins get a
+// server out of a state the transitions don't lead out of.
+func (l lifecycle) reset(caller entity.Caller, server *entity.Server, reason string) error {
+	from := server.Status
+
+	server.Status = entity.ServerStatusFailed
+	server.StatusReason = reason
+	server.StatusTime = helper.GetTodaysDateTimeISOString()
+
+	slog.Warn("server reset",
+		slog.String("userPrincipalName", server.UserPrincipalName),
+		slog.String("from", from),
+		slog.String("reason", reason),
+	)
+
+	if err := l.serverRepository.UpsertServerInDatabase(*server); err != nil {
+		slog.Error("not able to update server in database", err)
+		return err
+	}
+
+	event := entity.NewServerEvent(server.UserPrincipalName, entity.EventActionTransition, caller)
+	event.FromStatus = from
+	event.ToStatus = server.Status
+	event.Reason = reason
+	l.record(event)
+
+	l.meter(*server)
+
+	return nil
+}
+
+// record appends the event to the history. The change it describes has already happened,
+// so a failure is logged and not returned.
+func (l lifecycle) record(event entity.ServerEvent) {
+	if err := l.eventRepository.AppendEvent(event); err != nil {
+		slog.Error("not able to record server event",
+			slog.String("userPrincipalName", event.UserPrincipalName),
+			slog.String("action", event.Action),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// meter starts a usage interval when the server's container group comes up and ends the
+// open one when its compute goes away: when the server is redeployed, stopped or
+// destroyed. Servers that are stopping, destroying or failed are still metered, their
+// container group may well be running. Like the history, failures are logged and not
+// returned.
+func (l lifecycle) meter(server entity.Server) {
+	switch server.Status {
+	case entity.ServerStatusProvisioning, entity.ServerStatusStopped, entity.ServerStatusDestroyed:
+		l.endUsage(server)
+	case entity.ServerStatusWaitingForReady:
+		l.endUsage(server)
+		l.startUsage(server)
+	}
+}
+
+// endUsage ends the open usage intervals of the server.
+func (l lifecycle) endUsage(server entity.Server) {
+	open, err := l.usageRepository.ListUsageIntervals(entity.UsageQuery{
+		UserPrincipalName: server.UserPrincipalName,
+		OpenOnly:          true,
+	})
+	if err != nil {
+		l.meterFailed(server, err)
+		return
+	}
+
+	for _, interval := range open {
+		interval.End = time.Now().UTC().Format(entity.UsageTimeFormat)
+		if err := l.usageRepository.UpsertUsageInterval(interval); err != nil {
+			l.meterFailed(server, err)
+		}
+	}
+}
+
+// startUsage starts a usage interval with the resources the backend deployed the server
+// with.
+func (l lifecycle) startUsage(server entity.Server) {
+	// The caddy sidecar runs next to actlabs on every backend but container apps.
+	size := l.appConfig.ServerSize(server.Size)
+	backend := server.Backend
+	if backend == "" {
+		backend = l.appConfig.ComputeBackend
+	}
+
+	interval := entity.NewUsageInterval(server.UserPrincipalName, time.Now())
+	interval.Backend = backend
+	interval.Size = size.Name
+	interval.CPU = size.CPU
+	interval.Memory = size.Memory
+	if backend != entity.BackendAzureContainerApps {
+		interval.CPU += l.appConfig.CaddyCPU
+		interval.Memory += l.appConfig.CaddyMemory
+	}
+
+	if err := l.usageRepository.UpsertUsageInterval(interval); err != nil {
+		l.meterFailed(server, err)
+	}
+}
+
+func (l lifecycle) meterFailed(server entity.Server, err error) {
+	slog.Error("not able to record server usage",
+		slog.String("userPrincipalName", server.UserPrincipalName),
+		slog.String("status", server.Status),
+		slog.String("error", err.Error()),
+	)
+}
+
+func transitionIsStale(statusTime string) bool {
+	since, err := time.Parse(time.RFC3339, statusTime)
+	if err != nil {
+		// Records written before transitions were timed can't be stuck on an operation.
+		return true
+	}
+	return time.Since(since) > staleTransitionTimeout
+}
+
+// fail records the error as the reason the server failed and fails the operation.
+func (s *serverService) fail(caller entity.Caller, server entity.Server, tracker *operationTracker, err error) {
+	slog.Error("Error:", err)
+
+	if transitionErr := s.lifecycle.transition(caller, &server, entity.ServerStatusFailed, err.Error()); transitionErr != nil {
+		slog.Error("not able to mark server as failed", transitionErr)
+	}
+
+	tracker.Fail(server, err)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/fake"
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	tests := []struct {
+		name       string
+		backend    string
+		size       string
+		then       func(l lifecycle, server *entity.Server) error
+		wantOpen   bool
+		wantCPU    float64
+		wantMemory float64
+	}{
+		{name: "aci with caddy", backend: entity.BackendAzureContainerInstances, size: "medium", wantOpen: true, wantCPU: 1.5, wantMemory: 2.5},
+		{name: "container apps without caddy", backend: entity.BackendAzureContainerApps, size: "medium", wantOpen: true, wantCPU: 1, wantMemory: 2},
+		{name: "stopped", backend: entity.BackendAzureContainerInstances, size: "small", then: func(l lifecycle, server *entity.Server) error {
+			if err := l.transition(entity.Caller{}, server, entity.ServerStatusStopping, "stop requested"); err != nil {
+				return err
+			}
+			return l.transition(entity.Caller{}, server, entity.ServerStatusStopped, "stopped")
+		}, wantCPU: 1, wantMemory: 1},
+		{name: "failed to stop", backend: entity.BackendAzureContainerInstances, size: "small", then: func(l lifecycle, server *entity.Server) error {
+			if err := l.transition(entity.Caller{}, server, entity.ServerStatusStopping, "stop requested"); err != nil {
+				return err
+			}
+			return l.transition(entity.Caller{}, server, entity.ServerStatusFailed, "azure is down")
+		}, wantOpen: true, wantCPU: 1, wantMemory: 1},
+		{name: "reset by admin", backend: entity.BackendAzureContainerInstances, size: "small", then: func(l lifecycle, server *entity.Server) error {
+			return l.reset(entity.Caller{}, server, "reset by admin")
+		}, wantOpen: true, wantCPU: 1, wantMemory: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := fake.NewUsageRepository()
+			l := lifecycle{
+				serverRepository: fake.NewServerRepository(),
+				eventRepository:  fake.NewEventRepository(),
+				usageRepository:  usage,
+				appConfig:        testConfig(),
+			}
+
+			server := testServer()
+			server.Backend = tt.backend
+			server.Size = tt.size
+			for _, status := range []string{entity.ServerStatusProvisioning, entity.ServerStatusWaitingForReady, entity.ServerStatusRunning} {
+				if err := l.transition(entity.Caller{}, &server, status, status); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.then != nil {
+				if err := tt.then(l, &server); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			intervals, err := usage.ListUsageIntervals(entity.UsageQuery{
+				UserPrincipalName: server.UserPrincipalName,
+				From:              time.Now().Add(-time.Hour),
+				To:                time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(intervals) != 1 {
+				t.Fatalf("intervals = %d, want 1", len(intervals))
+			}
+
+			interval := intervals[0]
+			if open := interval.End == ""; open != tt.wantOpen {
+				t.Errorf("interval open = %v, want %v", open, tt.wantOpen)
+			}
+			if interval.CPU != tt.wantCPU || interval.Memory != tt.wantMemory {
+				t.Errorf("interval cpu, memory = %g, %g, want %g, %g", interval.CPU, interval.Memory, tt.wantCPU, tt.wantMemory)
+			}
+		})
+	}
+}
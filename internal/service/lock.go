@@ -0,0 +1,112 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const serverLockKeyPrefix = "actlabs-managed-server:server:"
+
+// serverLocks are the per-user locks that keep two changes to the same server, from two
+// browser tabs or a tab and the reaper, from running at the same time.
+type serverLocks struct {
+	locker entity.Locker
+	ttl    time.Duration
+}
+
+func (l serverLocks) key(server entity.Server) string {
+	return serverLockKeyPrefix + server.UserPrincipalName
+}
+
+// acquire takes the server's lock for owner. It returns entity.ErrServerLocked when
+// someone else holds it.
+func (l serverLocks) acquire(server entity.Server, owner string) (*serverLease, error) {
+	key := l.key(server)
+
+	ok, err := l.locker.Acquire(key, owner, l.ttl)
+	if err != nil {
+		return nil, fmt.Errorf("not able to lock server: %w", err)
+	}
+	if !ok {
+		return nil, entity.ErrServerLocked
+	}
+
+	lease := &serverLease{
+		locker: l.locker,
+		key:    key,
+		owner:  owner,
+		ttl:    l.ttl,
+		stop:   make(chan struct{}),
+	}
+	go lease.renew()
+
+	return lease, nil
+}
+
+// holder returns the owner of the server's lock, empty if nobody holds it.
+func (l serverLocks) holder(server entity.Server) (string, error) {
+	return l.locker.Holder(l.key(server))
+}
+
+// release frees the server's lock whoever holds it. The operation holding it, if it is
+// still running, loses the lock the next time it renews it.
+func (l serverLocks) release(server entity.Server) error {
+	holder, err := l.holder(server)
+	if err != nil || holder == "" {
+		return err
+	}
+	return l.locker.Release(l.key(server), holder)
+}
+
+// serverLease is a held server lock. It is renewed in the background until it is
+// released, so the lock outlives the ttl only while the replica holding it is alive.
+type serverLease struct {
+	locker entity.Locker
+	key    string
+	owner  string
+	ttl    time.Duration
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (l *serverLease) renew() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ok, err := l.locker.Renew(l.key, l.owner, l.ttl)
+			if err != nil {
+				slog.Error("not able to renew server lock",
+					slog.String("key", l.key),
+					slog.String("error", err.Error()),
+				)
+				continue
+			}
+			if !ok {
+				slog.Warn("server lock expired before it was released", slog.String("key", l.key))
+				return
+			}
+		}
+	}
+}
+
+// Release stops the renewal and gives the lock up. It is safe to call more than once.
+func (l *serverLease) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		if err := l.locker.Release(l.key, l.owner); err != nil {
+			slog.Error("not able to release server lock",
+				slog.String("key", l.key),
+				slog.String("error", err.Error()),
+			)
+		}
+	})
+}
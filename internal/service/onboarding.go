@@ -0,0 +1,220 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Storage account names are 3 to 24 lowercase letters and numbers.
+var storageAccountNameInvalidChars = regexp.MustCompile("[^a-z0-9]")
+
+type onboardingService struct {
+	onboardingRepository entity.OnboardingRepository
+	serverRepository     entity.ServerRepository
+	operationRepository  entity.OperationRepository
+	worker               *Worker
+	// Reused for ownership validation, defaults and the identity so that onboarding
+	// prepares exactly what a deployment uses.
+	servers   *serverService
+	appConfig *config.Config
+}
+
+func NewOnboardingService(
+	onboardingRepository entity.OnboardingRepository,
+	serverRepository entity.ServerRepository,
+	operationRepository entity.OperationRepository,
+	eventRepository entity.EventRepository,
+	usageRepository entity.UsageRepository,
+	locker entity.Locker,
+	worker *Worker,
+	appConfig *config.Config,
+) entity.OnboardingService {
+	return &onboardingService{
+		onboardingRepository: onboardingRepository,
+		serverRepository:     serverRepository,
+		operationRepository:  operationRepository,
+		worker:               worker,
+		servers: &serverService{
+			serverAccess: serverAccess{
+				serverRepository: serverRepository,
+				appConfig:        appConfig,
+			},
+			operationRepository:  operationRepository,
+			onboardingRepository: onboardingRepository,
+			eventRepository:      eventRepository,
+			lifecycle: lifecycle{
+				serverRepository: serverRepository,
+				eventRepository:  eventRepository,
+				usageRepository:  usageRepository,
+				appConfig:        appConfig,
+			},
+			locks: serverLocks{
+				locker: locker,
+				ttl:    time.Duration(appConfig.ServerLockTTLSeconds) * time.Second,
+			},
+			worker: worker,
+		},
+		appConfig: appConfig,
+	}
+}
+
+// Onboard does what scripts/setup.sh does. Every step checks before it creates, so
+// onboarding can be repeated safely. It holds the server's lock like the other changes,
+// since it changes the identity's role assignments and the record.
+func (o *onboardingService) Onboard(server entity.Server, caller entity.Caller) (entity.Operation, error) {
+	if err := o.servers.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	o.servers.ServerDefaults(&server)
+
+	lease, err := o.servers.lock(server)
+	if err != nil {
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	if _, err := o.servers.ownServerRecord(server, caller); err != nil {
+		lease.Release()
+		return entity.Operation{}, err
+	}
+
+	tracker, err := newOperationTracker(o.operationRepository, lease.owner, "onboarding", server)
+	if err != nil {
+		lease.Release()
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	if err := o.worker.Submit(func() {
+		defer lease.Release()
+		o.onboard(caller, server, tracker)
+	}, func(err error) {
+		o.fail(caller, server, tracker, err)
+	}); err != nil {
+		lease.Release()
+		o.fail(caller, server, tracker, err)
+		return tracker.Operation(), err
+	}
+
+	return tracker.Operation(), nil
+}
+
+func (o *onboardingService) onboard(caller entity.Caller, server entity.Server, tracker *operationTracker) {
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"resourceGroup", func() error {
+			created, err := o.onboardingRepository.EnsureResourceGroup(server)
+			if err == nil {
+				tracker.Progress(createdOrExists("resource group "+server.ResourceGroup, created))
+			}
+			return err
+		}},
+		{"storageAccount", func() error {
+			return o.ensureStorageAccount(&server, tracker)
+		}},
+		{"blobContainers", func() error {
+			for _, container := range entity.OnboardingBlobContainers {
+				created, err := o.onboardingRepository.EnsureBlobContainer(server, container)
+				if err != nil {
+					return err
+				}
+				tracker.Progress(createdOrExists("blob container "+container, created))
+			}
+			return nil
+		}},
+		{"identity", func() error {
+			return o.servers.UserAssignedIdentity(&server)
+		}},
+		{"roleAssignment", func() error {
+			return o.servers.ensureRoleAssignments(server, tracker.Progress)
+		}},
+	}
+
+	for _, step := range steps {
+		if err := tracker.Step(step.name, step.fn); err != nil {
+			o.fail(caller, server, tracker, err)
+			return
+		}
+	}
+
+	// Keep the rest of the record, the user may already have a server.
+	record := o.servers.serverRecord(server)
+	record.UserPrincipalId = server.UserPrincipalId
+	record.UserPrincipalName = server.UserPrincipalName
+	record.UserAlias = server.UserAlias
+	record.SubscriptionId = server.SubscriptionId
+	record.ResourceGroup = server.ResourceGroup
+	record.StorageAccountName = server.StorageAccountName
+	record.ManagedIdentityResourceId = server.ManagedIdentityResourceId
+	record.ManagedIdentityClientId = server.ManagedIdentityClientId
+	record.ManagedIdentityPrincipalId = server.ManagedIdentityPrincipalId
+
+	if err := o.serverRepository.UpsertServerInDatabase(record); err != nil {
+		slog.Error("not able to update server in database", err)
+		o.fail(caller, record, tracker, err)
+		return
+	}
+
+	o.record(caller, record, "onboarded")
+	tracker.Succeed(record)
+}
+
+// fail fails the operation and records the failure in the server's history. The server's
+// state is left alone, onboarding doesn't touch the container group.
+func (o *onboardingService) fail(caller entity.Caller, server entity.Server, tracker *operationTracker, err error) {
+	slog.Error("Error:", err)
+	o.record(caller, server, "onboarding failed: "+err.Error())
+	tracker.Fail(server, err)
+}
+
+func (o *onboardingService) record(caller entity.Caller, server entity.Server, reason string) {
+	event := entity.NewServerEvent(server.UserPrincipalName, entity.EventActionOnboarding, caller)
+	event.Reason = reason
+	o.servers.lifecycle.record(event)
+}
+
+// ensureStorageAccount reuses the storage account in the resource group if there is one,
+// otherwise it creates one named like setup.sh does, "<alias>sa<random>".
+func (o *onboardingService) ensureStorageAccount(server *entity.Server, tracker *operationTracker) error {
+	name, err := o.onboardingRepository.GetStorageAccountName(*server)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		server.StorageAccountName = name
+		tracker.Progress(createdOrExists("storage account "+name, false))
+		return nil
+	}
+
+	alias := storageAccountNameInvalidChars.ReplaceAllString(strings.TrimPrefix(strings.ToLower(server.UserAlias), "v-"), "")
+	if len(alias) > 14 {
+		alias = alias[:14]
+	}
+	name = alias + "sa" + helper.Generate(8)
+
+	if err := o.onboardingRepository.CreateStorageAccount(*server, name); err != nil {
+		return err
+	}
+
+	server.StorageAccountName = name
+	tracker.Progress(createdOrExists("storage account "+name, true))
+	return nil
+}
+
+func createdOrExists(resource string, created bool) string {
+	if created {
+		return fmt.Sprintf("%s created", resource)
+	}
+	return fmt.Sprintf("%s already exists", resource)
+}
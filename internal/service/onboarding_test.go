@@ -0,0 +1,52 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/fake"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Onboarding is refused before any of the onboarding repository is used, none is needed.
+func TestOnboardRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func(server *entity.Server)
+		locked  bool
+		wantErr error
+	}{
+		{name: "other user's server", request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }, wantErr: entity.ErrForbidden},
+		{name: "change in progress", locked: true, wantErr: entity.ErrServerLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers := fake.NewServerRepository()
+			locker := fake.NewLocker()
+			onboarding := NewOnboardingService(nil, servers, fake.NewOperationRepository(), fake.NewEventRepository(), fake.NewUsageRepository(), locker, NewWorker(1, 1), testConfig())
+
+			if err := servers.UpsertServerInDatabase(testServer()); err != nil {
+				t.Fatal(err)
+			}
+			if tt.locked {
+				if _, err := locker.Acquire(serverLockKeyPrefix+testServer().UserPrincipalName, "operation", time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			request := testServer()
+			if tt.request != nil {
+				tt.request(&request)
+			}
+
+			if _, err := onboarding.Onboard(request, testCaller(request)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Onboard() = %v, want %v", err, tt.wantErr)
+			}
+
+			if holder, _ := locker.Holder(serverLockKeyPrefix + request.UserPrincipalName); tt.locked != (holder != "") {
+				t.Errorf("lock holder = %q after refusal", holder)
+			}
+		})
+	}
+}
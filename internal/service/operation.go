@@ -0,0 +1,192 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+type operationService struct {
+	operationRepository entity.OperationRepository
+}
+
+func NewOperationService(operationRepository entity.OperationRepository) entity.OperationService {
+	return &operationService{
+		operationRepository: operationRepository,
+	}
+}
+
+func (o *operationService) GetOperation(id string, userPrincipalId string) (entity.Operation, error) {
+	operation, err := o.operationRepository.GetOperation(id)
+	if err != nil {
+		return operation, err
+	}
+
+	// Don't leak the existence of operations that belong to someone else.
+	if operation.UserPrincipalId != userPrincipalId {
+		return entity.Operation{}, entity.ErrOperationNotFound
+	}
+
+	return operation, nil
+}
+
+func (o *operationService) StreamOperation(id string, userPrincipalId string) (entity.Operation, <-chan entity.OperationEvent, func(), error) {
+	// Subscribe before reading the current state so that no event falls in between.
+	events, unsubscribe, err := o.operationRepository.SubscribeOperationEvents(id)
+	if err != nil {
+		return entity.Operation{}, nil, nil, err
+	}
+
+	operation, err := o.GetOperation(id, userPrincipalId)
+	if err != nil {
+		unsubscribe()
+		return entity.Operation{}, nil, nil, err
+	}
+
+	return operation, events, unsubscribe, nil
+}
+
+// operationTracker records the progress of a background operation and persists
+// every change so that clients polling the operation see each step as it happens.
+type operationTracker struct {
+	mu                  sync.Mutex
+	operation           entity.Operation
+	operationRepository entity.OperationRepository
+}
+
+func newOperationTracker(operationRepository entity.OperationRepository, id string, operationType string, server entity.Server) (*operationTracker, error) {
+	now := helper.GetTodaysDateTimeISOString()
+	t := &operationTracker{
+		operationRepository: operationRepository,
+		operation: entity.Operation{
+			Id:                id,
+			Type:              operationType,
+			Status:            entity.OperationStatusPending,
+			UserPrincipalId:   server.UserPrincipalId,
+			UserPrincipalName: server.UserPrincipalName,
+			Steps:             []entity.OperationStep{},
+			Server:            server,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		},
+	}
+
+	if err := operationRepository.UpsertOperation(t.operation); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Operation returns a copy of the current state of the operation.
+func (t *operationTracker) Operation() entity.Operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	operation := t.operation
+	operation.Steps = append([]entity.OperationStep{}, t.operation.Steps...)
+	return operation
+}
+
+// Step runs fn as a named step of the operation, recording when it started, when it
+// finished and whether it failed.
+func (t *operationTracker) Step(name string, fn func() error) error {
+	t.update(entity.OperationEventStepStarted, name, "", func(operation *entity.Operation) {
+		operation.Status = entity.OperationStatusRunning
+		operation.Steps = append(operation.Steps, entity.OperationStep{
+			Name:      name,
+			Status:    entity.OperationStatusRunning,
+			StartedAt: helper.GetTodaysDateTimeISOString(),
+		})
+	})
+
+	err := fn()
+
+	eventType := entity.OperationEventStepSucceeded
+	message := ""
+	if err != nil {
+		eventType = entity.OperationEventStepFailed
+		message = err.Error()
+	}
+
+	t.update(eventType, name, message, func(operation *entity.Operation) {
+		step := &operation.Steps[len(operation.Steps)-1]
+		step.FinishedAt = helper.GetTodaysDateTimeISOString()
+		step.Status = entity.OperationStatusSucceeded
+		if err != nil {
+			step.Status = entity.OperationStatusFailed
+			step.Message = err.Error()
+		}
+	})
+
+	return err
+}
+
+// Progress updates the message of the step that is currently running.
+func (t *operationTracker) Progress(message string) {
+	t.update(entity.OperationEventStepProgress, t.currentStep(), message, func(operation *entity.Operation) {
+		if len(operation.Steps) == 0 {
+			return
+		}
+		operation.Steps[len(operation.Steps)-1].Message = message
+	})
+}
+
+func (t *operationTracker) Succeed(server entity.Server) {
+	t.update(entity.OperationEventOperationSucceeded, "", "", func(operation *entity.Operation) {
+		operation.Status = entity.OperationStatusSucceeded
+		operation.Server = server
+	})
+}
+
+func (t *operationTracker) Fail(server entity.Server, err error) {
+	t.update(entity.OperationEventOperationFailed, "", err.Error(), func(operation *entity.Operation) {
+		operation.Status = entity.OperationStatusFailed
+		operation.Server = server
+		operation.Error = err.Error()
+	})
+}
+
+func (t *operationTracker) currentStep() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.operation.Steps) == 0 {
+		return ""
+	}
+	return t.operation.Steps[len(t.operation.Steps)-1].Name
+}
+
+// update applies fn to the operation, persists it and publishes the matching event.
+func (t *operationTracker) update(eventType string, step string, message string, fn func(operation *entity.Operation)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fn(&t.operation)
+	t.operation.UpdatedAt = helper.GetTodaysDateTimeISOString()
+
+	if err := t.operationRepository.UpsertOperation(t.operation); err != nil {
+		slog.Error("not able to persist operation",
+			slog.String("operationId", t.operation.Id),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	event := entity.OperationEvent{
+		OperationId: t.operation.Id,
+		Type:        eventType,
+		Step:        step,
+		Status:      t.operation.Status,
+		Message:     message,
+		Timestamp:   t.operation.UpdatedAt,
+	}
+
+	if err := t.operationRepository.PublishOperationEvent(event); err != nil {
+		slog.Error("not able to publish operation event",
+			slog.String("operationId", t.operation.Id),
+			slog.String("error", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// policyRules are the rules of the policy file that apply to the caller, the default rules
+// with the rules of the caller's overrides on top.
+func (s *serverService) policyRules(caller entity.Caller) entity.PolicyRules {
+	rules := s.appConfig.Policy.Rules
+
+	objectIds := []string{strings.ToLower(caller.PrincipalId)}
+	for _, group := range caller.Groups {
+		objectIds = append(objectIds, strings.ToLower(group))
+	}
+
+	for _, override := range s.appConfig.Policy.Overrides {
+		applies := false
+		for _, objectId := range override.ObjectIds {
+			applies = applies || helper.Contains(objectIds, objectId)
+		}
+		if !applies {
+			continue
+		}
+
+		if override.Rules.AllowedRegions != nil {
+			rules.AllowedRegions = override.Rules.AllowedRegions
+		}
+		if override.Rules.AllowedResourceGroups != nil {
+			rules.AllowedResourceGroups = override.Rules.AllowedResourceGroups
+		}
+		if override.Rules.AllowedImageChannels != nil {
+			rules.AllowedImageChannels = override.Rules.AllowedImageChannels
+		}
+		if override.Rules.AllowedSizes != nil {
+			rules.AllowedSizes = override.Rules.AllowedSizes
+		}
+		if override.Rules.AllowedLogLevels != nil {
+			rules.AllowedLogLevels = override.Rules.AllowedLogLevels
+		}
+		if override.Rules.AllowedSubscriptions != nil {
+			rules.AllowedSubscriptions = override.Rules.AllowedSubscriptions
+		}
+		if override.Rules.MaxInactivityMinutes != 0 {
+			rules.MaxInactivityMinutes = override.Rules.MaxInactivityMinutes
+		}
+	}
+
+	return rules
+}
+
+// checkPolicy evaluates the policy file against the settings the server has. Settings
+// that are not set are left to the defaults, which are checked once they are applied.
+func (s *serverService) checkPolicy(server entity.Server, caller entity.Caller) error {
+	if s.appConfig.Policy == nil {
+		return nil
+	}
+
+	rules := s.policyRules(caller)
+
+	violation := func(rule string, value string, allowed []string) error {
+		slog.Error("Error: policy violation",
+			slog.String("userPrincipalName", server.UserPrincipalName),
+			slog.String("rule", rule),
+			slog.String("value", value),
+		)
+		return &entity.PolicyViolationError{Rule: rule, Value: value, Allowed: allowed}
+	}
+
+	allowed := func(allowed []string, value string) bool {
+		return allowed == nil || value == "" || helper.Contains(allowed, value)
+	}
+
+	if !allowed(rules.AllowedSubscriptions, strings.ToLower(server.SubscriptionId)) {
+		return violation(entity.PolicyRuleAllowedSubscriptions, server.SubscriptionId, rules.AllowedSubscriptions)
+	}
+
+	if !allowed(rules.AllowedRegions, helper.NormalizeRegion(server.Region)) {
+		return violation(entity.PolicyRuleAllowedRegions, server.Region, rules.AllowedRegions)
+	}
+
+	if rules.AllowedResourceGroups != nil && server.ResourceGroup != "" {
+		matched := false
+		for _, pattern := range rules.AllowedResourceGroups {
+			ok, _ := path.Match(pattern, strings.ToLower(server.ResourceGroup))
+			matched = matched || ok
+		}
+		if !matched {
+			return violation(entity.PolicyRuleAllowedResourceGroups, server.ResourceGroup, rules.AllowedResourceGroups)
+		}
+	}
+
+	if !allowed(rules.AllowedImageChannels, server.ImageChannel) {
+		return violation(entity.PolicyRuleAllowedImageChannels, server.ImageChannel, rules.AllowedImageChannels)
+	}
+
+	if !allowed(rules.AllowedSizes, server.Size) {
+		return violation(entity.PolicyRuleAllowedSizes, server.Size, rules.AllowedSizes)
+	}
+
+	if !allowed(rules.AllowedLogLevels, server.LogLevel) {
+		return violation(entity.PolicyRuleAllowedLogLevels, server.LogLevel, rules.AllowedLogLevels)
+	}
+
+	// The inactivity window only matters when the server is destroyed or stopped when idle.
+	if rules.MaxInactivityMinutes > 0 && server.AutoDestroy && server.InactivityDurationInMinutes > rules.MaxInactivityMinutes {
+		return violation(
+			entity.PolicyRuleMaxInactivityMinutes,
+			strconv.Itoa(server.InactivityDurationInMinutes),
+			[]string{"up to " + strconv.Itoa(rules.MaxInactivityMinutes)},
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"fmt"
+
+	"golang.org/x/exp/slog"
+)
+
+// Resource providers each compute backend needs registered in the user's subscription.
+var backendResourceProviders = map[string][]string{
+	entity.BackendAzureContainerInstances: {"Microsoft.ContainerInstance", "Microsoft.ManagedIdentity"},
+	entity.BackendAzureContainerApps:      {"Microsoft.App", "Microsoft.ManagedIdentity"},
+}
+
+const onboardingRemediation = "Onboard the subscription again from the web UI or with POST /onboarding."
+
+func (s *serverService) Preflight(server entity.Server, caller entity.Caller) (entity.PreflightResult, error) {
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.PreflightResult{}, err
+	}
+
+	s.ServerDefaults(&server)
+
+	// Preflight tells whether the server can be deployed, which needs it to be allowed.
+	if err := s.checkAllowed(server, caller); err != nil {
+		return entity.PreflightResult{}, err
+	}
+
+	return s.preflight(server, true), nil
+}
+
+// preflight runs the checks in order and stops at the first one that the later ones
+// depend on. Deployments skip the identity checks, deploying creates the identity and
+// grants its roles.
+func (s *serverService) preflight(server entity.Server, checkIdentity bool) entity.PreflightResult {
+	result := entity.PreflightResult{Passed: true}
+
+	add := func(check entity.PreflightCheck) bool {
+		result.Checks = append(result.Checks, check)
+		if !check.Passed {
+			result.Passed = false
+		}
+		return check.Passed
+	}
+
+	record := s.serverRecord(server)
+
+	backend := server.Backend
+	if backend == "" {
+		backend = record.Backend
+	}
+	if backend == "" {
+		backend = s.appConfig.ComputeBackend
+	}
+
+	for _, namespace := range backendResourceProviders[backend] {
+		registered, err := s.onboardingRepository.ResourceProviderRegistered(server, namespace)
+		add(preflightCheck("resourceProvider:"+namespace, registered, err,
+			"resource provider "+namespace+" is registered",
+			"resource provider "+namespace+" is not registered",
+			"Register it with 'az provider register --namespace "+namespace+"' or in the portal under Subscriptions > Resource providers.",
+		))
+	}
+
+	exists, err := s.onboardingRepository.ResourceGroupExists(server)
+	if !add(preflightCheck("resourceGroup", exists, err,
+		"resource group "+server.ResourceGroup+" exists",
+		"resource group "+server.ResourceGroup+" does not exist",
+		onboardingRemediation,
+	)) {
+		return result
+	}
+
+	if server.StorageAccountName == "" {
+		server.StorageAccountName = record.StorageAccountName
+	}
+	if server.StorageAccountName == "" {
+		server.StorageAccountName, err = s.onboardingRepository.GetStorageAccountName(server)
+	}
+	if !add(preflightCheck("storageAccount", server.StorageAccountName != "", err,
+		"storage account "+server.StorageAccountName+" exists",
+		"there is no storage account in resource group "+server.ResourceGroup,
+		onboardingRemediation,
+	)) {
+		return result
+	}
+
+	for _, container := range entity.OnboardingBlobContainers {
+		exists, err := s.onboardingRepository.BlobContainerExists(server, container)
+		add(preflightCheck("blobContainer:"+container, exists, err,
+			"blob container "+container+" exists",
+			"blob container "+container+" does not exist in storage account "+server.StorageAccountName,
+			onboardingRemediation,
+		))
+	}
+
+	if !checkIdentity {
+		return result
+	}
+
+	server, err = s.serverRepository.GetUserAssignedManagedIdentity(server)
+	if !add(preflightCheck("identity", err == nil, nil,
+		"managed identity "+server.UserAlias+"-msi exists",
+		"managed identity "+server.UserAlias+"-msi does not exist",
+		onboardingRemediation,
+	)) {
+		return result
+	}
+
+	roleAssignments, err := s.serverRepository.ListManagedIdentityRoleAssignments(server)
+	missing := s.missingRoles(roleAssignments)
+	add(preflightCheck("roleAssignment", len(missing) == 0, err,
+		"managed identity has its roles on the subscription",
+		"managed identity is missing roles "+helper.SliceToString(missing)+" on the subscription",
+		onboardingRemediation,
+	))
+
+	return result
+}
+
+// preflightCheck fails the check when the prerequisite could not be inspected, the
+// remediation then points at the error.
+func preflightCheck(name string, passed bool, err error, passedMessage string, failedMessage string, remediation string) entity.PreflightCheck {
+	if err != nil {
+		return entity.PreflightCheck{
+			Name:        name,
+			Passed:      false,
+			Message:     fmt.Sprintf("not able to check: %s", err.Error()),
+			Remediation: "Make sure the subscription exists and actlabs has access to it.",
+		}
+	}
+
+	if passed {
+		return entity.PreflightCheck{Name: name, Passed: true, Message: passedMessage}
+	}
+
+	return entity.PreflightCheck{Name: name, Passed: false, Message: failedMessage, Remediation: remediation}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const reaperLockKey = "actlabs-managed-server:reaper"
+
+type reaperService struct {
+	serverRepository entity.ServerRepository
+	lifecycle        lifecycle
+	locks            serverLocks
+	locker           entity.Locker
+	appConfig        *config.Config
+	owner            string
+}
+
+func NewReaperService(
+	serverRepository entity.ServerRepository,
+	eventRepository entity.EventRepository,
+	usageRepository entity.UsageRepository,
+	locker entity.Locker,
+	appConfig *config.Config,
+) entity.ReaperService {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &reaperService{
+		serverRepository: serverRepository,
+		lifecycle: lifecycle{
+			serverRepository: serverRepository,
+			eventRepository:  eventRepository,
+			usageRepository:  usageRepository,
+			appConfig:        appConfig,
+		},
+		locks: serverLocks{
+			locker: locker,
+			ttl:    time.Duration(appConfig.ServerLockTTLSeconds) * time.Second,
+		},
+		locker:    locker,
+		appConfig: appConfig,
+		owner:     hostname + "-" + helper.Generate(8),
+	}
+}
+
+func (r *reaperService) Start(ctx context.Context) {
+	interval := time.Duration(r.appConfig.ReaperIntervalSeconds) * time.Second
+
+	slog.Info("starting reaper",
+		slog.Duration("interval", interval),
+		slog.Bool("dryRun", r.appConfig.ReaperDryRun),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("stopping reaper")
+			return
+		case <-ticker.C:
+			// Only one replica sweeps per interval. The lock is left to expire instead of
+			// being released so that replicas with a slightly later tick skip this round.
+			ok, err := r.locker.Acquire(reaperLockKey, r.owner, interval)
+			if err != nil {
+				slog.Error("reaper not able to acquire lock", slog.String("error", err.Error()))
+				continue
+			}
+			if !ok {
+				slog.Debug("reaper lock held by another replica, skipping")
+				continue
+			}
+
+			if _, err := r.Reap(); err != nil {
+				slog.Error("reaper sweep failed", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (r *reaperService) Reap() ([]entity.ReapDecision, error) {
+	servers, err := r.serverRepository.ListServersFromDatabase(entity.ServerQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing servers: %w", err)
+	}
+
+	decisions := []entity.ReapDecision{}
+	for _, server := range servers {
+		decision := r.reapServer(server)
+		logReapDecision(decision)
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+func (r *reaperService) reapServer(server entity.Server) entity.ReapDecision {
+	decision := entity.ReapDecision{
+		UserPrincipalName:   server.UserPrincipalName,
+		UserAlias:           server.UserAlias,
+		SubscriptionId:      server.SubscriptionId,
+		Action:              "skip",
+		InactivityThreshold: server.InactivityDurationInMinutes,
+		DryRun:              r.appConfig.ReaperDryRun,
+	}
+
+	if reason, expired := r.inactivityExpired(server, &decision); !expired {
+		decision.Reason = reason
+		return decision
+	}
+
+	// Hold the user's lock so that the server is not reaped while the user changes it.
+	if !r.appConfig.ReaperDryRun {
+		lease, err := r.locks.acquire(server, r.owner)
+		if errors.Is(err, entity.ErrServerLocked) {
+			decision.Reason = "another change to the server is in progress"
+			return decision
+		}
+		if err != nil {
+			decision.Action = "error"
+			decision.Reason = err.Error()
+			return decision
+		}
+		defer lease.Release()
+	}
+
+	// Re-read the record, activity may have been reported since the list was taken.
+	server, err := r.serverRepository.GetServerFromDatabase(server.PartitionKey, server.RowKey)
+	if err != nil {
+		decision.Action = "error"
+		decision.Reason = "not able to refresh server record: " + err.Error()
+		return decision
+	}
+	if reason, expired := r.inactivityExpired(server, &decision); !expired {
+		decision.Reason = reason
+		return decision
+	}
+
+	decision.Action = "reap"
+	decision.Reason = fmt.Sprintf("idle for %d minutes, threshold is %d minutes", decision.IdleMinutes, decision.InactivityThreshold)
+
+	if r.appConfig.ReaperDryRun {
+		return decision
+	}
+
+	status, done, verb := entity.ServerStatusDestroying, entity.ServerStatusDestroyed, "destroy"
+	reap := r.serverRepository.DestroyAzureContainerGroup
+	if decision.IdleAction == entity.IdleActionStop {
+		status, done, verb = entity.ServerStatusStopping, entity.ServerStatusStopped, "stop"
+		reap = r.serverRepository.StopAzureContainerGroup
+	}
+
+	if err := r.lifecycle.transition(entity.ReaperCaller, &server, status, decision.Reason); err != nil {
+		decision.Action = "error"
+		decision.Reason = "not able to " + verb + " server: " + err.Error()
+		return decision
+	}
+
+	if err := reap(server); err != nil {
+		decision.Action = "error"
+		decision.Reason = "not able to " + verb + " container group: " + err.Error()
+		if transitionErr := r.lifecycle.transition(entity.ReaperCaller, &server, entity.ServerStatusFailed, decision.Reason); transitionErr != nil {
+			slog.Error("not able to mark server as failed", transitionErr)
+		}
+		return decision
+	}
+
+	if err := r.lifecycle.transition(entity.ReaperCaller, &server, done, "reaped after "+decision.Reason); err != nil {
+		decision.Action = "error"
+		decision.Reason = "container group " + done + " but not able to update server in database: " + err.Error()
+		return decision
+	}
+
+	return decision
+}
+
+// inactivityExpired reports whether the server is eligible for reaping. When it is not,
+// the returned string explains why.
+func (r *reaperService) inactivityExpired(server entity.Server, decision *entity.ReapDecision) (string, bool) {
+	if server.Status != entity.ServerStatusRunning {
+		return "server status is " + server.Status, false
+	}
+
+	if !server.AutoDestroy {
+		return "auto destroy is disabled", false
+	}
+
+	if server.InactivityDurationInMinutes <= 0 {
+		return "inactivity duration is not set", false
+	}
+
+	lastActivity, err := time.Parse(time.RFC3339, server.LastUserActivityTime)
+	if err != nil {
+		return "not able to parse last activity time " + server.LastUserActivityTime, false
+	}
+
+	decision.IdleMinutes = int(time.Since(lastActivity).Minutes())
+	decision.InactivityThreshold = server.InactivityDurationInMinutes
+
+	// Records written before idle actions existed don't have one.
+	decision.IdleAction = server.IdleAction
+	if decision.IdleAction == "" {
+		decision.IdleAction = r.appConfig.DefaultIdleAction
+	}
+
+	if decision.IdleMinutes < server.InactivityDurationInMinutes {
+		return fmt.Sprintf("idle for %d minutes, threshold is %d minutes", decision.IdleMinutes, server.InactivityDurationInMinutes), false
+	}
+
+	return "", true
+}
+
+func logReapDecision(decision entity.ReapDecision) {
+	attrs := []any{
+		slog.String("userPrincipalName", decision.UserPrincipalName),
+		slog.String("userAlias", decision.UserAlias),
+		slog.String("subscriptionId", decision.SubscriptionId),
+		slog.String("action", decision.Action),
+		slog.String("idleAction", decision.IdleAction),
+		slog.String("reason", decision.Reason),
+		slog.Int("idleMinutes", decision.IdleMinutes),
+		slog.Int("inactivityThreshold", decision.InactivityThreshold),
+		slog.Bool("dryRun", decision.DryRun),
+	}
+
+	switch decision.Action {
+	case "error":
+		slog.Error("reaper decision", attrs...)
+	case "reap":
+		slog.Info("reaper decision", attrs...)
+	default:
+		slog.Debug("reaper decision", attrs...)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/fake"
+	"testing"
+	"time"
+)
+
+func TestReapServer(t *testing.T) {
+	idle := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	active := time.Now().Add(-5 * time.Minute).Format(time.RFC3339)
+
+	tests := []struct {
+		name         string
+		idleAction   string
+		lastActivity string
+		lockedBy     string
+		wantAction   string
+		wantStatus   string
+		wantGroup    bool
+	}{
+		{name: "destroys idle server", idleAction: entity.IdleActionDestroy, lastActivity: idle, wantAction: "reap", wantStatus: entity.ServerStatusDestroyed},
+		{name: "stops idle server", idleAction: entity.IdleActionStop, lastActivity: idle, wantAction: "reap", wantStatus: entity.ServerStatusStopped, wantGroup: true},
+		{name: "skips active server", idleAction: entity.IdleActionDestroy, lastActivity: active, wantAction: "skip", wantStatus: entity.ServerStatusRunning, wantGroup: true},
+		{name: "skips locked server", idleAction: entity.IdleActionDestroy, lastActivity: idle, lockedBy: "operation", wantAction: "skip", wantStatus: entity.ServerStatusRunning, wantGroup: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig := testConfig()
+			appConfig.ReaperDryRun = false
+
+			serverRepository := fake.NewServerRepository()
+			locker := fake.NewLocker()
+			reaper := NewReaperService(serverRepository, fake.NewEventRepository(), fake.NewUsageRepository(), locker, appConfig).(*reaperService)
+
+			server := entity.Server{
+				UserPrincipalName:           "user@example.com",
+				UserAlias:                   "user",
+				SubscriptionId:              "subscription",
+				Status:                      entity.ServerStatusRunning,
+				AutoDestroy:                 true,
+				InactivityDurationInMinutes: 60,
+				IdleAction:                  tt.idleAction,
+				LastUserActivityTime:        tt.lastActivity,
+			}
+			if _, err := serverRepository.DeployAzureContainerGroup(server); err != nil {
+				t.Fatal(err)
+			}
+			if err := serverRepository.UpsertServerInDatabase(server); err != nil {
+				t.Fatal(err)
+			}
+			server, _ = serverRepository.GetServerFromDatabase("actlabs", server.UserPrincipalName)
+
+			if tt.lockedBy != "" {
+				if _, err := locker.Acquire(serverLockKeyPrefix+server.UserPrincipalName, tt.lockedBy, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			decision := reaper.reapServer(server)
+			if decision.Action != tt.wantAction {
+				t.Fatalf("action = %s (%s), want %s", decision.Action, decision.Reason, tt.wantAction)
+			}
+
+			record, err := serverRepository.GetServerFromDatabase("actlabs", server.UserPrincipalName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", record.Status, tt.wantStatus)
+			}
+
+			if _, ok := serverRepository.ContainerGroup(server.UserAlias); ok != tt.wantGroup {
+				t.Errorf("container group exists = %v, want %v", ok, tt.wantGroup)
+			}
+
+			holder, _ := locker.Holder(serverLockKeyPrefix + server.UserPrincipalName)
+			if holder != tt.lockedBy {
+				t.Errorf("lock holder = %q, want %q", holder, tt.lockedBy)
+			}
+		})
+	}
+}
@@ -4,108 +4,703 @@ import (
 	"actlabs-managed-server/internal/config"
 	"actlabs-managed-server/internal/entity"
 	"actlabs-managed-server/internal/helper"
+	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/exp/slog"
 )
 
-type serverService struct {
+const (
+	logsFollowInterval = 3 * time.Second
+	logsFollowTail     = 500
+)
+
+var (
+	// Resource group names are up to 90 letters, numbers, underscores, parentheses,
+	// hyphens and periods, and don't end with a period.
+	resourceGroupNamePattern = regexp.MustCompile(`^[\w()\-.]{0,89}[\w()\-]$`)
+	// The alias names the container group, container app and managed identity. The
+	// container app's, "<alias>-aca", is the strictest, up to 32 letters, numbers and
+	// hyphens that start with a letter.
+	userAliasPattern = regexp.MustCompile(`(?i)^[a-z][a-z0-9-]{0,26}[a-z0-9]$`)
+)
+
+// serverAccess validates requests and finds the records of the caller's servers. Services
+// that only read or open a user's server hold it instead of a serverService.
+type serverAccess struct {
 	serverRepository entity.ServerRepository
 	appConfig        *config.Config
 }
 
+type serverService struct {
+	serverAccess
+	operationRepository  entity.OperationRepository
+	onboardingRepository entity.OnboardingRepository
+	eventRepository      entity.EventRepository
+	lifecycle            lifecycle
+	locks                serverLocks
+	releaseChannels      *releaseChannelService
+	worker               *Worker
+}
+
 func NewServerService(
 	serverRepository entity.ServerRepository,
+	operationRepository entity.OperationRepository,
+	onboardingRepository entity.OnboardingRepository,
+	eventRepository entity.EventRepository,
+	usageRepository entity.UsageRepository,
+	locker entity.Locker,
+	releaseChannelRepository entity.ReleaseChannelRepository,
+	worker *Worker,
 	appConfig *config.Config,
 ) entity.ServerService {
 	return &serverService{
-		serverRepository: serverRepository,
-		appConfig:        appConfig,
+		serverAccess: serverAccess{
+			serverRepository: serverRepository,
+			appConfig:        appConfig,
+		},
+		operationRepository:  operationRepository,
+		onboardingRepository: onboardingRepository,
+		eventRepository:      eventRepository,
+		lifecycle: lifecycle{
+			serverRepository: serverRepository,
+			eventRepository:  eventRepository,
+			usageRepository:  usageRepository,
+			appConfig:        appConfig,
+		},
+		locks: serverLocks{
+			locker: locker,
+			ttl:    time.Duration(appConfig.ServerLockTTLSeconds) * time.Second,
+		},
+		releaseChannels: &releaseChannelService{
+			releaseChannelRepository: releaseChannelRepository,
+			serverRepository:         serverRepository,
+			appConfig:                appConfig,
+		},
+		worker: worker,
 	}
 }
 
-func (s *serverService) DeployServer(server entity.Server) (entity.Server, error) {
+// DeployServer validates the request and queues the deployment. The returned operation
+// can be polled for progress while the deployment runs in the background.
+func (s *serverService) DeployServer(server entity.Server, caller entity.Caller) (entity.Operation, error) {
 
 	// Validate input.
-	if err := s.Validate(server); err != nil {
+	if err := s.Validate(server, caller); err != nil {
 		slog.Error("Error:", err)
-		return server, err
+		return entity.Operation{}, err
+	}
+
+	// The record is looked up by the userPrincipalName of the request, a deployment must
+	// not take over another user's server.
+	record, err := s.ownServerRecord(server, caller)
+	if err != nil {
+		return entity.Operation{}, err
+	}
+
+	// Keep the channel and size picked before when the request doesn't name them.
+	if server.ImageChannel == "" || server.Size == "" {
+		if server.ImageChannel == "" {
+			server.ImageChannel = record.ImageChannel
+		}
+		if server.Size == "" {
+			server.Size = record.Size
+		}
 	}
 
 	s.ServerDefaults(&server) // Set defaults.
-	// s.ContainerAppEnvironment(&server) // Create container app environment if it doesn't exist.
-	s.UserAssignedIdentity(&server) // Managed Identity
 
-	server, err := s.serverRepository.DeployAzureContainerGroup(server)
+	if err := s.checkServerSize(server, caller); err != nil {
+		return entity.Operation{}, err
+	}
+
+	// Checked once the defaults are applied, they have to be allowed too.
+	if err := s.checkAllowed(server, caller); err != nil {
+		return entity.Operation{}, err
+	}
+
+	image, err := s.releaseChannels.releaseChannelImage(server.ImageChannel)
 	if err != nil {
 		slog.Error("Error:", err)
-		return server, err
+		return entity.Operation{}, err
+	}
+	server.Image = image
+
+	lease, err := s.lock(server)
+	if err != nil {
+		// The same deployment asked for again while it runs, e.g. after a page reload.
+		var lockedErr *entity.ServerLockedError
+		if errors.As(err, &lockedErr) && lockedErr.Operation != nil &&
+			lockedErr.Operation.Type == "deploy" && !lockedErr.Operation.IsTerminal() &&
+			sameDeployment(lockedErr.Operation.Server, server) {
+			return *lockedErr.Operation, nil
+		}
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	// A running server that already looks like the request is not deployed again.
+	if record := s.serverRecord(server); record.Status == entity.ServerStatusRunning && sameDeployment(record, server) {
+		defer lease.Release()
+
+		tracker, err := newOperationTracker(s.operationRepository, lease.owner, "deploy", record)
+		if err != nil {
+			slog.Error("Error:", err)
+			return entity.Operation{}, err
+		}
+		tracker.Succeed(record)
+		return tracker.Operation(), nil
+	}
+
+	// Fail before anything is created when the subscription is not ready for a server.
+	if s.appConfig.PreflightOnDeploy {
+		if result := s.preflight(server, false); !result.Passed {
+			lease.Release()
+			err := &entity.PreflightError{Result: result}
+			slog.Error("Error:", err)
+			return entity.Operation{}, err
+		}
+	}
+
+	return s.submit(caller, lease, "deploy", server, entity.ServerStatusProvisioning, "deploy requested", s.deployServer)
+}
+
+func (s *serverService) deployServer(caller entity.Caller, server entity.Server, tracker *operationTracker) {
+	if err := tracker.Step("identity", func() error {
+		return s.UserAssignedIdentity(&server) // Managed Identity
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
+	}
+
+	if err := tracker.Step("roleAssignment", func() error {
+		return s.ensureRoleAssignments(server, tracker.Progress)
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
 	}
 
+	if err := tracker.Step("containerGroup", func() error {
+		var err error
+		server, err = s.serverRepository.DeployAzureContainerGroup(server)
+		return err
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
+	}
+
+	s.finishWhenReady(caller, server, tracker)
+}
+
+// lock takes the server's lock for a new operation, the lock's owner is the id of the
+// operation. When the lock is held the error is a *entity.ServerLockedError.
+func (s *serverService) lock(server entity.Server) (*serverLease, error) {
+	lease, err := s.locks.acquire(server, uuid.NewString())
+	if !errors.Is(err, entity.ErrServerLocked) {
+		return lease, err
+	}
+
+	lockedErr := &entity.ServerLockedError{}
+	holder, holderErr := s.locks.holder(server)
+	if holderErr != nil || holder == "" {
+		return nil, lockedErr
+	}
+	// The holder is not an operation when it is a synchronous request or the reaper.
+	if operation, err := s.operationRepository.GetOperation(holder); err == nil {
+		lockedErr.Operation = &operation
+	}
+	return nil, lockedErr
+}
+
+// sameDeployment reports whether deploying the request would give the server it already has.
+func sameDeployment(current entity.Server, request entity.Server) bool {
+	return current.SubscriptionId == request.SubscriptionId &&
+		helper.NormalizeRegion(current.Region) == request.Region &&
+		current.LogLevel == request.LogLevel &&
+		current.AutoCreate == request.AutoCreate &&
+		current.AutoDestroy == request.AutoDestroy &&
+		current.InactivityDurationInMinutes == request.InactivityDurationInMinutes &&
+		current.IdleAction == request.IdleAction &&
+		current.Image == request.Image &&
+		current.Size == request.Size &&
+		(request.Backend == "" || current.Backend == request.Backend)
+}
+
+// submit moves the server to the given state and queues fn as an operation that holds
+// the lease until it finishes. The state is changed first so that conflicting requests
+// are refused before anything is queued.
+func (s *serverService) submit(
+	caller entity.Caller,
+	lease *serverLease,
+	operationType string,
+	server entity.Server,
+	status string,
+	reason string,
+	fn func(caller entity.Caller, server entity.Server, tracker *operationTracker),
+) (entity.Operation, error) {
+	if err := s.lifecycle.transition(caller, &server, status, reason); err != nil {
+		lease.Release()
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	tracker, err := newOperationTracker(s.operationRepository, lease.owner, operationType, server)
+	if err != nil {
+		lease.Release()
+		slog.Error("Error:", err)
+		if transitionErr := s.lifecycle.transition(caller, &server, entity.ServerStatusFailed, err.Error()); transitionErr != nil {
+			slog.Error("not able to mark server as failed", transitionErr)
+		}
+		return entity.Operation{}, err
+	}
+
+	if err := s.worker.Submit(func() {
+		defer lease.Release()
+		fn(caller, server, tracker)
+	}, func(err error) {
+		s.fail(caller, server, tracker, err)
+	}); err != nil {
+		lease.Release()
+		s.fail(caller, server, tracker, err)
+		return tracker.Operation(), err
+	}
+
+	return tracker.Operation(), nil
+}
+
+// finishWhenReady waits for the server to respond once its container group is up and
+// marks it running.
+func (s *serverService) finishWhenReady(caller entity.Caller, server entity.Server, tracker *operationTracker) {
+	if err := s.lifecycle.transition(caller, &server, entity.ServerStatusWaitingForReady, "container group is up"); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
+	}
+
+	if err := tracker.Step("readiness", func() error {
+		return s.waitForServerUp(server, tracker)
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
+	}
+
+	slog.Info("Server is up and running")
+
+	server.LastUserActivityTime = time.Now().Format(time.RFC3339)
+
+	if err := s.lifecycle.transition(caller, &server, entity.ServerStatusRunning, "server is up"); err != nil {
+		slog.Error("Error:", err)
+		tracker.Fail(server, err)
+		return
+	}
+
+	tracker.Succeed(server)
+}
+
+// waitForServerUp polls the server every 5 seconds until it is up or the configured wait time is over.
+func (s *serverService) waitForServerUp(server entity.Server, tracker *operationTracker) error {
 	// convert to int
 	waitTimeSeconds, err := strconv.Atoi(s.appConfig.ActlabsServerUPWaitTimeSeconds)
 	if err != nil {
-		slog.Error("Error:", err)
-		return server, err
+		return err
 	}
 
-	// Ensure server is up and running. check every 5 seconds for 3 minutes.
-	for i := 0; i < waitTimeSeconds/5; i++ {
+	attempts := waitTimeSeconds / 5
+	for i := 0; i < attempts; i++ {
+		tracker.Progress(fmt.Sprintf("attempt %d of %d", i+1, attempts))
 		if err := s.serverRepository.EnsureServerUp(server); err == nil {
-			slog.Info("Server is up and running")
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	return fmt.Errorf("server did not come up within %d seconds", waitTimeSeconds)
+}
+
+func (s *serverService) DestroyServer(server entity.Server, caller entity.Caller) error {
+
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return err
+	}
+
+	s.ServerDefaults(&server)
+
+	if _, err := s.ownServerRecord(server, caller); err != nil {
+		return err
+	}
+
+	return s.destroyServer(caller, server, "destroyed on request")
+}
+
+// destroyServer destroys the container group under the server's lock. The ownership of
+// the server has been checked by the caller.
+func (s *serverService) destroyServer(caller entity.Caller, server entity.Server, reason string) error {
+	lease, err := s.lock(server)
+	if err != nil {
+		slog.Error("Error:", err)
+		return err
+	}
+	defer lease.Release()
+
+	server = s.serverRecord(server)
+
+	if err := s.lifecycle.transition(caller, &server, entity.ServerStatusDestroying, "destroy requested"); err != nil {
+		slog.Error("Error:", err)
+		return err
+	}
+
+	if err := s.serverRepository.DestroyAzureContainerGroup(server); err != nil {
+		slog.Error("Error:", err)
+		if transitionErr := s.lifecycle.transition(caller, &server, entity.ServerStatusFailed, err.Error()); transitionErr != nil {
+			slog.Error("not able to mark server as failed", transitionErr)
+		}
+		return err
+	}
+
+	return s.lifecycle.transition(caller, &server, entity.ServerStatusDestroyed, reason)
+}
+
+// TeardownServer removes the server and everything created for it in the background.
+func (s *serverService) TeardownServer(server entity.Server, caller entity.Caller) (entity.Operation, error) {
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
 
-			server.Status = "running"
-			server.LastUserActivityTime = time.Now().Format(time.RFC3339)
+	s.ServerDefaults(&server)
+
+	lease, err := s.lock(server)
+	if err != nil {
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	// Checked before anything is removed, the record is the only proof of ownership left
+	// once the identity and role assignments are gone.
+	server, err = s.ownServerRecord(server, caller)
+	if err != nil {
+		lease.Release()
+		return entity.Operation{}, err
+	}
+
+	return s.submit(caller, lease, "teardown", server, entity.ServerStatusDestroying, "teardown requested", s.teardownServer)
+}
 
-			// Update server in database.
-			if err := s.serverRepository.UpsertServerInDatabase(server); err != nil {
-				slog.Error("not able to update server in database", err)
+// teardownServer keeps going when a step fails so that as much as possible is removed,
+// the operation reports which steps need another attempt.
+func (s *serverService) teardownServer(caller entity.Caller, server entity.Server, tracker *operationTracker) {
+	failed := []string{}
+
+	if err := tracker.Step("containerGroup", func() error {
+		return s.serverRepository.DestroyAzureContainerGroup(server)
+	}); err != nil {
+		failed = append(failed, "containerGroup")
+	} else {
+		// The server never reaches destroyed, its record goes away instead.
+		s.lifecycle.endUsage(server)
+	}
+
+	// The identity's principal id is needed to find its role assignments.
+	identity, identityErr := s.serverRepository.GetUserAssignedManagedIdentity(server)
+
+	if err := tracker.Step("roleAssignments", func() error {
+		if identityErr != nil {
+			tracker.Progress("managed identity not found, no role assignments to delete")
+			return nil
+		}
+
+		roleAssignments, err := s.serverRepository.ListManagedIdentityRoleAssignments(identity)
+		if err != nil {
+			return err
+		}
+		for _, roleAssignment := range roleAssignments {
+			if err := s.serverRepository.DeleteManagedIdentityRoleAssignment(identity, roleAssignment.Id); err != nil {
+				return err
 			}
+			tracker.Progress("deleted role assignment " + roleAssignment.Id)
+		}
+		return nil
+	}); err != nil {
+		failed = append(failed, "roleAssignments")
+	}
+
+	if err := tracker.Step("identity", func() error {
+		return s.serverRepository.DeleteUserAssignedManagedIdentity(server)
+	}); err != nil {
+		failed = append(failed, "identity")
+	}
+
+	// The record is kept when anything else is left behind, it is how the server is found again.
+	if len(failed) > 0 {
+		s.fail(caller, server, tracker, fmt.Errorf("teardown incomplete, failed steps: %s", helper.SliceToString(failed)))
+		return
+	}
+
+	if err := tracker.Step("record", func() error {
+		return s.serverRepository.DeleteServerFromDatabase("actlabs", server.UserPrincipalName)
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
+	}
+
+	server.Status = entity.ServerStatusDestroyed
+	server.StatusReason = "torn down on request"
+	server.StatusTime = helper.GetTodaysDateTimeISOString()
+	tracker.Succeed(server)
+}
+
+// StopServer hibernates the server. The container group and its DNS label are kept so
+// that the server can be started again with the same endpoint.
+func (s *serverService) StopServer(server entity.Server, caller entity.Caller) (entity.Server, error) {
+
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return server, err
+	}
+
+	s.ServerDefaults(&server)
+
+	lease, err := s.lock(server)
+	if err != nil {
+		slog.Error("Error:", err)
+		return server, err
+	}
+	defer lease.Release()
+
+	server, err = s.ownServerRecord(server, caller)
+	if err != nil {
+		return server, err
+	}
+
+	if err := s.lifecycle.transition(caller, &server, entity.ServerStatusStopping, "stop requested"); err != nil {
+		slog.Error("Error:", err)
+		return server, err
+	}
 
-			return server, err
+	if err := s.serverRepository.StopAzureContainerGroup(server); err != nil {
+		slog.Error("Error:", err)
+		if transitionErr := s.lifecycle.transition(caller, &server, entity.ServerStatusFailed, err.Error()); transitionErr != nil {
+			slog.Error("not able to mark server as failed", transitionErr)
 		}
-		time.Sleep(5 * time.Second)
+		return server, err
 	}
 
-	server.Status = "failed"
+	if err := s.lifecycle.transition(caller, &server, entity.ServerStatusStopped, "stopped on request"); err != nil {
+		return server, err
+	}
 
 	return server, nil
 }
 
-func (s *serverService) DestroyServer(server entity.Server) error {
+// StartServer resumes a stopped server in the background and waits for it to be up.
+func (s *serverService) StartServer(server entity.Server, caller entity.Caller) (entity.Operation, error) {
+
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	s.ServerDefaults(&server)
 
-	if err := s.Validate(server); err != nil {
+	lease, err := s.lock(server)
+	if err != nil {
 		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	server, err = s.ownServerRecord(server, caller)
+	if err != nil {
+		lease.Release()
+		return entity.Operation{}, err
+	}
+
+	if err := s.checkAllowed(server, caller); err != nil {
+		lease.Release()
+		return entity.Operation{}, err
+	}
+
+	return s.submit(caller, lease, "start", server, entity.ServerStatusProvisioning, "start requested", s.startServer)
+}
+
+func (s *serverService) startServer(caller entity.Caller, server entity.Server, tracker *operationTracker) {
+	if err := tracker.Step("containerGroup", func() error {
+		if err := s.serverRepository.StartAzureContainerGroup(server); err != nil {
+			return err
+		}
+
+		var err error
+		server, err = s.serverRepository.GetAzureContainerGroup(server)
 		return err
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
+	}
+
+	s.finishWhenReady(caller, server, tracker)
+}
+
+// RestartServer restarts a wedged server in place in the background and waits for it to be up.
+func (s *serverService) RestartServer(server entity.Server, caller entity.Caller) (entity.Operation, error) {
+
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.Operation{}, err
 	}
 
 	s.ServerDefaults(&server)
 
-	if err := s.serverRepository.DestroyAzureContainerGroup(server); err != nil {
+	lease, err := s.lock(server)
+	if err != nil {
 		slog.Error("Error:", err)
+		return entity.Operation{}, err
+	}
+
+	server, err = s.ownServerRecord(server, caller)
+	if err != nil {
+		lease.Release()
+		return entity.Operation{}, err
+	}
+
+	return s.submit(caller, lease, "restart", server, entity.ServerStatusProvisioning, "restart requested", s.restartServer)
+}
+
+func (s *serverService) restartServer(caller entity.Caller, server entity.Server, tracker *operationTracker) {
+	if err := tracker.Step("containerGroup", func() error {
+		if err := s.serverRepository.RestartAzureContainerGroup(server); err != nil {
+			return err
+		}
+
+		var err error
+		server, err = s.serverRepository.GetAzureContainerGroup(server)
 		return err
+	}); err != nil {
+		s.fail(caller, server, tracker, err)
+		return
 	}
 
-	return nil
+	s.finishWhenReady(caller, server, tracker)
 }
 
-func (s *serverService) GetServer(server entity.Server) (entity.Server, error) {
+func (s *serverService) GetServer(server entity.Server, caller entity.Caller) (entity.Server, error) {
 	// Validate input.
-	if err := s.Validate(server); err != nil {
+	if err := s.Validate(server, caller); err != nil {
 		slog.Error("Error:", err)
 		return server, err
 	}
 
 	s.ServerDefaults(&server) // Set defaults.
 
-	return s.serverRepository.GetAzureContainerGroup(server)
+	return s.liveServer(server)
+}
+
+// liveServer returns the server as the compute backend sees it with the lifecycle state
+// of the stored record.
+func (s *serverService) liveServer(server entity.Server) (entity.Server, error) {
+	live, err := s.serverRepository.GetAzureContainerGroup(server)
+	if err != nil {
+		return live, err
+	}
+
+	// The lifecycle state is the manager's, the backend only reports its provisioning state.
+	if record, err := s.serverRepository.GetServerFromDatabase("actlabs", server.UserPrincipalName); err == nil {
+		live.Status = record.Status
+		live.StatusReason = record.StatusReason
+		live.StatusTime = record.StatusTime
+	}
+
+	return live, nil
+}
+
+func (s *serverService) GetServerLogs(server entity.Server, caller entity.Caller, containerName string, tail int) (string, error) {
+	if err := s.validateLogsRequest(server, caller, containerName, tail); err != nil {
+		return "", err
+	}
+
+	s.ServerDefaults(&server)
+
+	return s.serverRepository.GetContainerLogs(server, containerName, tail, false)
+}
+
+// FollowServerLogs polls the container logs and sends lines that were not seen before.
+// ACI has no native follow, so lines are de-duplicated using their timestamps.
+func (s *serverService) FollowServerLogs(ctx context.Context, server entity.Server, caller entity.Caller, containerName string, tail int) (<-chan string, error) {
+	if err := s.validateLogsRequest(server, caller, containerName, tail); err != nil {
+		return nil, err
+	}
+
+	s.ServerDefaults(&server)
+
+	lines := make(chan string)
+
+	go func() {
+		defer close(lines)
+
+		ticker := time.NewTicker(logsFollowInterval)
+		defer ticker.Stop()
+
+		lastTimestamp := ""
+		for {
+			logs, err := s.serverRepository.GetContainerLogs(server, containerName, tail, true)
+			if err != nil {
+				slog.Error("not able to get container logs", slog.String("error", err.Error()))
+			}
+
+			for _, line := range strings.Split(strings.TrimRight(logs, "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+
+				timestamp, _, _ := strings.Cut(line, " ")
+				if lastTimestamp != "" && timestamp <= lastTimestamp {
+					continue
+				}
+				lastTimestamp = timestamp
+
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			// Only the first poll honors the requested tail, later polls only need recent lines.
+			tail = logsFollowTail
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return lines, nil
+}
+
+func (s *serverService) validateLogsRequest(server entity.Server, caller entity.Caller, containerName string, tail int) error {
+	if err := s.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return err
+	}
+
+	if !helper.Contains(entity.ServerContainerNames, containerName) {
+		return fmt.Errorf("%w: container must be one of %s", entity.ErrInvalidLogsRequest, helper.SliceToString(entity.ServerContainerNames))
+	}
+
+	if tail < 0 {
+		return fmt.Errorf("%w: tail must not be negative", entity.ErrInvalidLogsRequest)
+	}
+
+	return nil
 }
 
-func (s *serverService) UpdateActivityStatus(userPrincipalName string) error {
+func (s *serverService) UpdateActivityStatus(userPrincipalName string, caller entity.Caller) error {
 	server, err := s.serverRepository.GetServerFromDatabase("actlabs", userPrincipalName)
 	if err != nil {
 		slog.Error("Error getting server from database:", err)
@@ -119,10 +714,43 @@ func (s *serverService) UpdateActivityStatus(userPrincipalName string) error {
 		return fmt.Errorf("error updating server in database: %w", err)
 	}
 
+	s.lifecycle.record(entity.NewServerEvent(userPrincipalName, entity.EventActionActivity, caller))
+
 	return nil
 }
 
-func (s *serverService) Validate(server entity.Server) error {
+// ListServerEvents returns a page of the server's history, newest first. Admins may read
+// the history of any user by setting the query's UserPrincipalName.
+func (s *serverService) ListServerEvents(server entity.Server, caller entity.Caller, query entity.EventQuery) (entity.EventPage, error) {
+	if query.UserPrincipalName != "" && query.UserPrincipalName != server.UserPrincipalName {
+		if !caller.Can(entity.PermissionServersRead) {
+			slog.Error("Error: reading the events of other users needs the " + string(entity.PermissionServersRead) + " permission")
+			return entity.EventPage{}, entity.ErrForbidden
+		}
+	} else {
+		if err := s.Validate(server, caller); err != nil {
+			slog.Error("Error:", err)
+			return entity.EventPage{}, err
+		}
+
+		record, err := s.ownStoredServerRecord(server, caller)
+		if err != nil {
+			return entity.EventPage{}, err
+		}
+		query.UserPrincipalName = record.UserPrincipalName
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = entity.DefaultEventPageSize
+	}
+	if query.Limit > entity.MaxEventPageSize {
+		query.Limit = entity.MaxEventPageSize
+	}
+
+	return s.eventRepository.ListEvents(query)
+}
+
+func (s *serverAccess) Validate(server entity.Server, caller entity.Caller) error {
 	if server.UserPrincipalName == "" || server.UserPrincipalId == "" || server.SubscriptionId == "" {
 		slog.Error("Error: userPrincipalName, userPrincipalId, and subscriptionId are required")
 		return errors.New("missing required information")
@@ -132,6 +760,32 @@ func (s *serverService) Validate(server entity.Server) error {
 		server.UserAlias = strings.Split(server.UserPrincipalName, "@")[0]
 	}
 
+	// Both end up in ARM paths and resource names.
+	if !userAliasPattern.MatchString(server.UserAlias) {
+		slog.Error("Error: invalid user alias " + server.UserAlias)
+		return fmt.Errorf("%w: userAlias must be 2 to 28 letters, numbers and hyphens that start with a letter", entity.ErrInvalidName)
+	}
+
+	if server.ResourceGroup != "" && !resourceGroupNamePattern.MatchString(server.ResourceGroup) {
+		slog.Error("Error: invalid resource group " + server.ResourceGroup)
+		return fmt.Errorf("%w: resourceGroup must be up to 90 letters, numbers, underscores, parentheses, hyphens and periods", entity.ErrInvalidName)
+	}
+
+	if server.IdleAction != "" && server.IdleAction != entity.IdleActionDestroy && server.IdleAction != entity.IdleActionStop {
+		slog.Error("Error: invalid idle action " + server.IdleAction)
+		return errors.New("idleAction must be either destroy or stop")
+	}
+
+	if _, ok := s.appConfig.ReleaseChannels[server.ImageChannel]; server.ImageChannel != "" && !ok {
+		slog.Error("Error: unknown release channel " + server.ImageChannel)
+		return fmt.Errorf("%w: %s", entity.ErrReleaseChannelNotFound, server.ImageChannel)
+	}
+
+	if server.Size != "" && s.serverSizeRank(server.Size) < 0 {
+		slog.Error("Error: unknown server size " + server.Size)
+		return fmt.Errorf("%w: %s", entity.ErrServerSizeNotFound, server.Size)
+	}
+
 	ok, err := s.serverRepository.IsUserOwner(server)
 	if err != nil {
 		slog.Error("Error:", err)
@@ -139,13 +793,25 @@ func (s *serverService) Validate(server entity.Server) error {
 	}
 	if !ok {
 		slog.Error("Error: user is not the owner of the subscription")
-		return errors.New("insufficient permissions")
+		return entity.ErrForbidden
 	}
 
 	return nil
 }
 
-func (s *serverService) ServerDefaults(server *entity.Server) {
+// checkAllowed checks the server against the allowed regions and the policy file. Only
+// deployments and starts are checked, a server that was allowed when it was deployed can
+// still be stopped, destroyed or torn down.
+func (s *serverService) checkAllowed(server entity.Server, caller entity.Caller) error {
+	if server.Region != "" && !helper.Contains(s.appConfig.AllowedRegions, helper.NormalizeRegion(server.Region)) {
+		slog.Error("Error: region not allowed " + server.Region)
+		return fmt.Errorf("%w: %s, allowed regions are %s", entity.ErrRegionNotAllowed, server.Region, helper.SliceToString(s.appConfig.AllowedRegions))
+	}
+
+	return s.checkPolicy(server, caller)
+}
+
+func (s *serverAccess) ServerDefaults(server *entity.Server) {
 	if server.UserAlias == "" {
 		server.UserAlias = helper.UserAlias(server.UserPrincipalName)
 	}
@@ -154,13 +820,68 @@ func (s *serverService) ServerDefaults(server *entity.Server) {
 		server.LogLevel = "0"
 	}
 
+	if server.ImageChannel == "" {
+		server.ImageChannel = s.appConfig.DefaultReleaseChannel
+	}
+
+	if server.Size == "" {
+		server.Size = s.appConfig.DefaultServerSize
+	}
+
+	server.Region = helper.NormalizeRegion(server.Region)
 	if server.Region == "" {
-		server.Region = "East US"
+		server.Region = s.appConfig.DefaultRegion
 	}
 
 	if server.ResourceGroup == "" {
 		server.ResourceGroup = "repro-project"
 	}
+
+	if server.IdleAction == "" {
+		server.IdleAction = s.appConfig.DefaultIdleAction
+	}
+}
+
+// serverRecord returns the stored record of the server so that settings saved at deploy
+// time survive partial requests. The request is used as is when there is no record yet.
+func (s *serverAccess) serverRecord(server entity.Server) entity.Server {
+	record, err := s.serverRepository.GetServerFromDatabase("actlabs", server.UserPrincipalName)
+	if err != nil {
+		slog.Info("Server not found in database, using request")
+		return server
+	}
+
+	return record
+}
+
+// ownServerRecord is serverRecord for requests that change the server. The record is
+// looked up by the userPrincipalName of the request, which the token doesn't vouch for,
+// so it must belong to the caller and the subscription of the request.
+func (s *serverAccess) ownServerRecord(server entity.Server, caller entity.Caller) (entity.Server, error) {
+	record := s.serverRecord(server)
+	if record.UserPrincipalId != caller.PrincipalId || record.SubscriptionId != server.SubscriptionId {
+		slog.Error("Error: server of " + server.UserPrincipalName + " is not the caller's")
+		return server, entity.ErrForbidden
+	}
+
+	return record, nil
+}
+
+// ownStoredServerRecord is ownServerRecord for reading what is kept by userPrincipalName,
+// the history and the usage. Only the record ties them to the caller, without one, e.g.
+// after a teardown, they are left to admins.
+func (s *serverAccess) ownStoredServerRecord(server entity.Server, caller entity.Caller) (entity.Server, error) {
+	record, err := s.serverRepository.GetServerFromDatabase("actlabs", server.UserPrincipalName)
+	if err != nil {
+		slog.Error("Error:", err)
+		return server, err
+	}
+	if record.UserPrincipalId != caller.PrincipalId {
+		slog.Error("Error: server of " + server.UserPrincipalName + " is not the caller's")
+		return server, entity.ErrForbidden
+	}
+
+	return record, nil
 }
 
 func (s *serverService) UserAssignedIdentity(server *entity.Server) error {
@@ -179,3 +900,53 @@ func (s *serverService) UserAssignedIdentity(server *entity.Server) error {
 
 	return nil
 }
+
+// ensureRoleAssignments grants the managed identity the configured roles on the subscription
+// and waits until the assignments are visible. A new identity takes a while to reach the
+// directory, creating its assignments is retried until then.
+func (s *serverService) ensureRoleAssignments(server entity.Server, progress func(message string)) error {
+	deadline := time.Now().Add(time.Duration(s.appConfig.RoleAssignmentTimeoutSeconds) * time.Second)
+
+	for {
+		roleAssignments, err := s.serverRepository.ListManagedIdentityRoleAssignments(server)
+		if err != nil {
+			return err
+		}
+
+		missing := s.missingRoles(roleAssignments)
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("roles %s not assigned to managed identity within %d seconds", helper.SliceToString(missing), s.appConfig.RoleAssignmentTimeoutSeconds)
+		}
+
+		for _, role := range missing {
+			if err := s.serverRepository.CreateManagedIdentityRoleAssignment(server, role); err != nil && !errors.Is(err, entity.ErrPrincipalNotFound) {
+				return err
+			}
+		}
+
+		progress("waiting for roles " + helper.SliceToString(missing))
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// missingRoles returns the configured roles that are not among the role assignments.
+func (s *serverService) missingRoles(roleAssignments []entity.RoleAssignment) []string {
+	missing := []string{}
+	for _, role := range s.appConfig.ManagedIdentityRoles {
+		assigned := false
+		for _, roleAssignment := range roleAssignments {
+			if roleAssignment.RoleDefinitionId == role {
+				assigned = true
+				break
+			}
+		}
+		if !assigned {
+			missing = append(missing, role)
+		}
+	}
+	return missing
+}
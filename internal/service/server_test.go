@@ -0,0 +1,476 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/fake"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testConfig is the configuration the service tests run with, the defaults of NewConfig
+// where the tests depend on them.
+func testConfig() *config.Config {
+	return &config.Config{
+		ActlabsServerUPWaitTimeSeconds: "5",
+		ActlabsCPU:                     0.5,
+		ActlabsMemory:                  0.5,
+		CaddyCPU:                       0.5,
+		CaddyMemory:                    0.5,
+		ReaperIntervalSeconds:          300,
+		DefaultIdleAction:              entity.IdleActionDestroy,
+		ComputeBackend:                 entity.BackendAzureContainerInstances,
+		ServerLockTTLSeconds:           60,
+		AllowedRegions:                 []string{"eastus", "westus2"},
+		DefaultRegion:                  "eastus",
+		ReleaseChannels:                map[string]string{"stable": "repro:latest", "alpha": "repro:alpha"},
+		DefaultReleaseChannel:          "alpha",
+		ServerSizes: []entity.ServerSize{
+			{Name: "small", CPU: 0.5, Memory: 0.5},
+			{Name: "medium", CPU: 1, Memory: 2},
+		},
+		DefaultServerSize: "small",
+		MaxServerSize:     "medium",
+	}
+}
+
+type testServerService struct {
+	*serverService
+	servers    *fake.ServerRepository
+	operations *fake.OperationRepository
+	events     *fake.EventRepository
+}
+
+func newTestServerService(t *testing.T) testServerService {
+	t.Helper()
+
+	servers := fake.NewServerRepository()
+	operations := fake.NewOperationRepository()
+	events := fake.NewEventRepository()
+	worker := NewWorker(1, 10)
+
+	service := NewServerService(servers, operations, nil, events, fake.NewUsageRepository(), fake.NewLocker(), fake.NewReleaseChannelRepository(), worker, testConfig()).(*serverService)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		worker.Shutdown(ctx)
+	})
+
+	return testServerService{
+		serverService: service,
+		servers:       servers,
+		operations:    operations,
+		events:        events,
+	}
+}
+
+// wait waits for the operations submitted so far to finish and returns the operation.
+func (s testServerService) wait(t *testing.T, operation entity.Operation) entity.Operation {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.worker.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	operation, err := s.operations.GetOperation(operation.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return operation
+}
+
+// seed stores the server with the status and, unless it is destroyed, its container group.
+func (s testServerService) seed(t *testing.T, server entity.Server, status string) {
+	t.Helper()
+
+	s.ServerDefaults(&server)
+	server.Status = status
+	if status != entity.ServerStatusDestroyed {
+		if _, err := s.servers.DeployAzureContainerGroup(server); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.servers.UpsertServerInDatabase(server); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testServer() entity.Server {
+	return entity.Server{
+		UserPrincipalId:   "user-oid",
+		UserPrincipalName: "user@example.com",
+		SubscriptionId:    "subscription",
+	}
+}
+
+func testCaller(server entity.Server) entity.Caller {
+	return entity.Caller{PrincipalId: server.UserPrincipalId}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  func(server *entity.Server)
+		owner   bool
+		wantErr error
+	}{
+		{name: "valid", owner: true},
+		{name: "missing subscription", server: func(server *entity.Server) { server.SubscriptionId = "" }, owner: true, wantErr: errors.New("missing required information")},
+		{name: "invalid idle action", server: func(server *entity.Server) { server.IdleAction = "pause" }, owner: true, wantErr: errors.New("idleAction must be either destroy or stop")},
+		{name: "unknown release channel", server: func(server *entity.Server) { server.ImageChannel = "nightly" }, owner: true, wantErr: entity.ErrReleaseChannelNotFound},
+		{name: "unknown size", server: func(server *entity.Server) { server.Size = "huge" }, owner: true, wantErr: entity.ErrServerSizeNotFound},
+		{name: "alias with a path", server: func(server *entity.Server) { server.UserAlias = "user/../../other" }, owner: true, wantErr: entity.ErrInvalidName},
+		{name: "alias from upn with a path", server: func(server *entity.Server) { server.UserPrincipalName = "a/b@example.com" }, owner: true, wantErr: entity.ErrInvalidName},
+		{name: "alias too long for a container app", server: func(server *entity.Server) { server.UserAlias = "abcdefghijklmnopqrstuvwxyz0123" }, owner: true, wantErr: entity.ErrInvalidName},
+		{name: "resource group with a path", server: func(server *entity.Server) { server.ResourceGroup = "rg/providers/x" }, owner: true, wantErr: entity.ErrInvalidName},
+		{name: "resource group ending with a period", server: func(server *entity.Server) { server.ResourceGroup = "repro." }, owner: true, wantErr: entity.ErrInvalidName},
+		{name: "resource group with parentheses", server: func(server *entity.Server) { server.ResourceGroup = "repro_(test).1" }, owner: true},
+		{name: "not subscription owner", owner: false, wantErr: entity.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServerService(t)
+			s.servers.SetOwner(tt.owner, nil)
+
+			server := testServer()
+			if tt.server != nil {
+				tt.server(&server)
+			}
+
+			err := s.Validate(server, testCaller(server))
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("Validate() = nil, want %v", tt.wantErr)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error():
+				t.Fatalf("Validate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeployServer(t *testing.T) {
+	tests := []struct {
+		name          string
+		failOn        string
+		wantOperation string
+		wantStatus    string
+		wantGroup     bool
+	}{
+		{name: "deploys", wantOperation: entity.OperationStatusSucceeded, wantStatus: entity.ServerStatusRunning, wantGroup: true},
+		{name: "identity fails", failOn: "CreateUserAssignedManagedIdentity", wantOperation: entity.OperationStatusFailed, wantStatus: entity.ServerStatusFailed},
+		{name: "container group fails", failOn: "DeployAzureContainerGroup", wantOperation: entity.OperationStatusFailed, wantStatus: entity.ServerStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServerService(t)
+			if tt.failOn != "" {
+				s.servers.FailOn(tt.failOn, errors.New("azure is down"))
+			}
+
+			server := testServer()
+			operation, err := s.DeployServer(server, testCaller(server))
+			if err != nil {
+				t.Fatalf("DeployServer() = %v", err)
+			}
+
+			operation = s.wait(t, operation)
+			if operation.Status != tt.wantOperation {
+				t.Errorf("operation status = %s (%s), want %s", operation.Status, operation.Error, tt.wantOperation)
+			}
+
+			record, err := s.servers.GetServerFromDatabase("actlabs", server.UserPrincipalName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", record.Status, tt.wantStatus)
+			}
+			if record.Image != "repro:alpha" || record.Size != "small" || record.Region != "eastus" {
+				t.Errorf("record image, size, region = %s, %s, %s, want the defaults", record.Image, record.Size, record.Region)
+			}
+
+			if _, ok := s.servers.ContainerGroup(record.UserAlias); ok != tt.wantGroup {
+				t.Errorf("container group exists = %v, want %v", ok, tt.wantGroup)
+			}
+
+			if len(s.events.Events(server.UserPrincipalName)) == 0 {
+				t.Error("no events recorded")
+			}
+		})
+	}
+}
+
+func TestServerOperations(t *testing.T) {
+	stop := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		_, err := s.StopServer(server, testCaller(server))
+		return entity.Operation{}, err
+	}
+	destroy := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return entity.Operation{}, s.DestroyServer(server, testCaller(server))
+	}
+	start := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return s.StartServer(server, testCaller(server))
+	}
+	restart := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return s.RestartServer(server, testCaller(server))
+	}
+	teardown := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return s.TeardownServer(server, testCaller(server))
+	}
+
+	// Another user asking for the server of user@example.com with their own token.
+	attacker := func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }
+	otherSubscription := func(server *entity.Server) { server.SubscriptionId = "attacker-subscription" }
+
+	tests := []struct {
+		name       string
+		run        func(s testServerService, server entity.Server) (entity.Operation, error)
+		status     string
+		request    func(server *entity.Server)
+		failOn     string
+		wantErr    error
+		wantStatus string // empty when the record is deleted
+		wantGroup  bool
+		wantCall   string
+	}{
+		{name: "stop", run: stop, status: entity.ServerStatusRunning, wantStatus: entity.ServerStatusStopped, wantGroup: true, wantCall: "StopAzureContainerGroup"},
+		{name: "stop fails", run: stop, status: entity.ServerStatusRunning, failOn: "StopAzureContainerGroup", wantErr: errors.New("azure is down"), wantStatus: entity.ServerStatusFailed, wantGroup: true, wantCall: "StopAzureContainerGroup"},
+		{name: "stop stopped server", run: stop, status: entity.ServerStatusStopped, wantErr: entity.ErrInvalidTransition, wantStatus: entity.ServerStatusStopped, wantGroup: true},
+		{name: "stop other user's server", run: stop, status: entity.ServerStatusRunning, request: attacker, wantErr: entity.ErrForbidden, wantStatus: entity.ServerStatusRunning, wantGroup: true},
+		{name: "stop from other subscription", run: stop, status: entity.ServerStatusRunning, request: otherSubscription, wantErr: entity.ErrForbidden, wantStatus: entity.ServerStatusRunning, wantGroup: true},
+		{name: "destroy", run: destroy, status: entity.ServerStatusRunning, wantStatus: entity.ServerStatusDestroyed, wantCall: "DestroyAzureContainerGroup"},
+		{name: "destroy other user's server", run: destroy, status: entity.ServerStatusRunning, request: attacker, wantErr: entity.ErrForbidden, wantStatus: entity.ServerStatusRunning, wantGroup: true},
+		{name: "start", run: start, status: entity.ServerStatusStopped, wantStatus: entity.ServerStatusRunning, wantGroup: true, wantCall: "StartAzureContainerGroup"},
+		{name: "start other user's server", run: start, status: entity.ServerStatusStopped, request: attacker, wantErr: entity.ErrForbidden, wantStatus: entity.ServerStatusStopped, wantGroup: true},
+		{name: "restart", run: restart, status: entity.ServerStatusRunning, wantStatus: entity.ServerStatusRunning, wantGroup: true, wantCall: "RestartAzureContainerGroup"},
+		{name: "restart other user's server", run: restart, status: entity.ServerStatusRunning, request: attacker, wantErr: entity.ErrForbidden, wantStatus: entity.ServerStatusRunning, wantGroup: true},
+		{name: "teardown", run: teardown, status: entity.ServerStatusRunning, wantCall: "DeleteUserAssignedManagedIdentity"},
+		{name: "teardown keeps record on failure", run: teardown, status: entity.ServerStatusRunning, failOn: "DeleteUserAssignedManagedIdentity", wantStatus: entity.ServerStatusFailed, wantCall: "DeleteUserAssignedManagedIdentity"},
+		{name: "teardown other user's server", run: teardown, status: entity.ServerStatusRunning, request: attacker, wantErr: entity.ErrForbidden, wantStatus: entity.ServerStatusRunning, wantGroup: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServerService(t)
+			s.seed(t, testServer(), tt.status)
+			if tt.failOn != "" {
+				s.servers.FailOn(tt.failOn, errors.New("azure is down"))
+			}
+
+			request := testServer()
+			if tt.request != nil {
+				tt.request(&request)
+			}
+
+			operation, err := tt.run(s, request)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("err = %v, want nil", err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("err = nil, want %v", tt.wantErr)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error():
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if operation.Id != "" {
+				s.wait(t, operation)
+			}
+
+			record, err := s.servers.GetServerFromDatabase("actlabs", "user@example.com")
+			switch {
+			case tt.wantStatus == "" && !errors.Is(err, entity.ErrServerNotFound):
+				t.Errorf("record status = %s, want the record deleted", record.Status)
+			case tt.wantStatus != "" && record.Status != tt.wantStatus:
+				t.Errorf("record status = %s, want %s", record.Status, tt.wantStatus)
+			}
+
+			if _, ok := s.servers.ContainerGroup("user"); ok != tt.wantGroup {
+				t.Errorf("container group exists = %v, want %v", ok, tt.wantGroup)
+			}
+
+			if tt.wantCall != "" && s.servers.CallCount(tt.wantCall) == 0 {
+				t.Errorf("%s not called", tt.wantCall)
+			}
+			if tt.wantCall == "" {
+				for _, call := range []string{"StopAzureContainerGroup", "StartAzureContainerGroup", "RestartAzureContainerGroup", "DestroyAzureContainerGroup", "DeleteUserAssignedManagedIdentity"} {
+					if s.servers.CallCount(call) > 0 {
+						t.Errorf("%s called", call)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDisallowedServer(t *testing.T) {
+	deploy := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return s.DeployServer(server, testCaller(server))
+	}
+	start := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return s.StartServer(server, testCaller(server))
+	}
+	destroy := func(s testServerService, server entity.Server) (entity.Operation, error) {
+		return entity.Operation{}, s.DestroyServer(server, testCaller(server))
+	}
+
+	// The server was deployed to eastus, which is no longer allowed.
+	regionRemoved := func(appConfig *config.Config) { appConfig.AllowedRegions = []string{"westus2"} }
+	subscriptionRemoved := func(appConfig *config.Config) {
+		appConfig.Policy = &entity.Policy{Rules: entity.PolicyRules{AllowedSubscriptions: []string{"other-subscription"}}}
+	}
+
+	tests := []struct {
+		name       string
+		run        func(s testServerService, server entity.Server) (entity.Operation, error)
+		status     string
+		config     func(appConfig *config.Config)
+		request    func(server *entity.Server)
+		wantErr    error
+		wantStatus string
+	}{
+		{name: "deploy to region not allowed", run: deploy, status: entity.ServerStatusDestroyed, request: func(server *entity.Server) { server.Region = "japaneast" }, wantErr: entity.ErrRegionNotAllowed, wantStatus: entity.ServerStatusDestroyed},
+		{name: "deploy to allowed region display name", run: deploy, status: entity.ServerStatusDestroyed, request: func(server *entity.Server) { server.Region = "West US 2" }, wantStatus: entity.ServerStatusRunning},
+		{name: "deploy to subscription not allowed", run: deploy, status: entity.ServerStatusDestroyed, config: subscriptionRemoved, wantErr: entity.ErrPolicyViolation, wantStatus: entity.ServerStatusDestroyed},
+		{name: "start in region no longer allowed", run: start, status: entity.ServerStatusStopped, config: regionRemoved, wantErr: entity.ErrRegionNotAllowed, wantStatus: entity.ServerStatusStopped},
+		{name: "start in subscription no longer allowed", run: start, status: entity.ServerStatusStopped, config: subscriptionRemoved, wantErr: entity.ErrPolicyViolation, wantStatus: entity.ServerStatusStopped},
+		{name: "destroy in region no longer allowed", run: destroy, status: entity.ServerStatusRunning, config: regionRemoved, wantStatus: entity.ServerStatusDestroyed},
+		{name: "destroy in subscription no longer allowed", run: destroy, status: entity.ServerStatusRunning, config: subscriptionRemoved, wantStatus: entity.ServerStatusDestroyed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServerService(t)
+			s.seed(t, testServer(), tt.status)
+			if tt.config != nil {
+				tt.config(s.appConfig)
+			}
+
+			request := testServer()
+			if tt.request != nil {
+				tt.request(&request)
+			}
+
+			operation, err := tt.run(s, request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if operation.Id != "" {
+				if operation = s.wait(t, operation); operation.Status != entity.OperationStatusSucceeded {
+					t.Errorf("operation status = %s (%s), want %s", operation.Status, operation.Error, entity.OperationStatusSucceeded)
+				}
+			}
+
+			record, err := s.servers.GetServerFromDatabase("actlabs", "user@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Status != tt.wantStatus {
+				t.Errorf("record status = %s, want %s", record.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeployServerOverOtherUsersServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		request func(server *entity.Server)
+	}{
+		{name: "other user", status: entity.ServerStatusRunning, request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }},
+		{name: "other user and subscription", status: entity.ServerStatusRunning, request: func(server *entity.Server) {
+			server.UserPrincipalId = "attacker-oid"
+			server.SubscriptionId = "attacker-subscription"
+		}},
+		{name: "destroyed server", status: entity.ServerStatusDestroyed, request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServerService(t)
+			s.seed(t, testServer(), tt.status)
+
+			request := testServer()
+			tt.request(&request)
+
+			operation, err := s.DeployServer(request, testCaller(request))
+			if !errors.Is(err, entity.ErrForbidden) {
+				t.Fatalf("DeployServer() = %v, want %v", err, entity.ErrForbidden)
+			}
+			if operation.Server.Endpoint != "" {
+				t.Errorf("endpoint %s returned to another user", operation.Server.Endpoint)
+			}
+
+			record, err := s.servers.GetServerFromDatabase("actlabs", "user@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.UserPrincipalId != "user-oid" || record.Status != tt.status {
+				t.Errorf("record = %s, %s, want it unchanged", record.UserPrincipalId, record.Status)
+			}
+
+			if holder, _ := s.locks.holder(record); holder != "" {
+				t.Errorf("lock held by %s", holder)
+			}
+		})
+	}
+}
+
+func TestListServerEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		seed        bool
+		request     func(server *entity.Server)
+		permissions []entity.Permission
+		query       entity.EventQuery
+		wantErr     error
+	}{
+		{name: "own history", seed: true},
+		{name: "other user's history", seed: true, request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }, wantErr: entity.ErrForbidden},
+		{name: "no record", request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }, wantErr: entity.ErrServerNotFound},
+		{name: "other user's history with permission", seed: true, request: func(server *entity.Server) {
+			server.UserPrincipalId = "admin-oid"
+			server.UserPrincipalName = "admin@example.com"
+		}, permissions: []entity.Permission{entity.PermissionServersRead}, query: entity.EventQuery{UserPrincipalName: "user@example.com"}},
+		{name: "other user's history without permission", seed: true, request: func(server *entity.Server) {
+			server.UserPrincipalId = "attacker-oid"
+			server.UserPrincipalName = "attacker@example.com"
+		}, query: entity.EventQuery{UserPrincipalName: "user@example.com"}, wantErr: entity.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServerService(t)
+			if tt.seed {
+				s.seed(t, testServer(), entity.ServerStatusRunning)
+			}
+			s.lifecycle.record(entity.NewServerEvent("user@example.com", entity.EventActionActivity, entity.Caller{PrincipalId: "user-oid", IP: "10.0.0.1"}))
+
+			request := testServer()
+			if tt.request != nil {
+				tt.request(&request)
+			}
+			caller := testCaller(request)
+			caller.Permissions = tt.permissions
+
+			page, err := s.ListServerEvents(request, caller, tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ListServerEvents() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && len(page.Events) != 1 {
+				t.Errorf("events = %d, want 1", len(page.Events))
+			}
+			if tt.wantErr != nil && len(page.Events) != 0 {
+				t.Errorf("events = %d, want none", len(page.Events))
+			}
+		})
+	}
+}
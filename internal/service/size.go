@@ -0,0 +1,50 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+func (s *serverService) ListServerSizes(caller entity.Caller) entity.ServerSizes {
+	return entity.ServerSizes{
+		Sizes:   s.appConfig.ServerSizes,
+		MaxSize: s.maxServerSize(caller),
+	}
+}
+
+// serverSizeRank is the position of the size in the configured sizes, -1 if there is
+// no such size.
+func (s *serverAccess) serverSizeRank(name string) int {
+	for i, size := range s.appConfig.ServerSizes {
+		if size.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// maxServerSize is the largest of the default limit and the limits of the caller and
+// their groups. The object ids come from the verified token, not from the request.
+func (s *serverService) maxServerSize(caller entity.Caller) string {
+	maxSize := s.appConfig.MaxServerSize
+	for _, objectId := range append([]string{caller.PrincipalId}, caller.Groups...) {
+		size, ok := s.appConfig.ServerSizeLimits[strings.ToLower(objectId)]
+		if ok && s.serverSizeRank(size) > s.serverSizeRank(maxSize) {
+			maxSize = size
+		}
+	}
+	return maxSize
+}
+
+// checkServerSize refuses sizes above the largest the caller may deploy.
+func (s *serverService) checkServerSize(server entity.Server, caller entity.Caller) error {
+	maxSize := s.maxServerSize(caller)
+	if s.serverSizeRank(server.Size) > s.serverSizeRank(maxSize) {
+		slog.Error("Error: server size " + server.Size + " above the limit " + maxSize)
+		return fmt.Errorf("%w: %s is larger than %s, the largest size you can deploy", entity.ErrServerSizeNotAllowed, server.Size, maxSize)
+	}
+	return nil
+}
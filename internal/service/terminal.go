@@ -0,0 +1,117 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/helper"
+	"fmt"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+type terminalService struct {
+	serverRepository   entity.ServerRepository
+	terminalRepository entity.TerminalRepository
+	servers            serverAccess
+	appConfig          *config.Config
+}
+
+func NewTerminalService(
+	serverRepository entity.ServerRepository,
+	terminalRepository entity.TerminalRepository,
+	appConfig *config.Config,
+) entity.TerminalService {
+	return &terminalService{
+		serverRepository:   serverRepository,
+		terminalRepository: terminalRepository,
+		servers: serverAccess{
+			serverRepository: serverRepository,
+			appConfig:        appConfig,
+		},
+		appConfig: appConfig,
+	}
+}
+
+func (t *terminalService) StartSession(server entity.Server, request entity.TerminalRequest, caller entity.Caller) (entity.TerminalSession, entity.ContainerExec, error) {
+	if err := t.servers.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.TerminalSession{}, entity.ContainerExec{}, err
+	}
+
+	t.servers.ServerDefaults(&server)
+
+	// The container is found by the userPrincipalName of the request, a session must not
+	// open in another user's server.
+	server, err := t.servers.ownServerRecord(server, caller)
+	if err != nil {
+		return entity.TerminalSession{}, entity.ContainerExec{}, err
+	}
+
+	if request.ContainerName == "" {
+		request.ContainerName = "actlabs"
+	}
+	if request.Command == "" {
+		request.Command = entity.TerminalCommands[0]
+	}
+	if request.Rows <= 0 {
+		request.Rows = 24
+	}
+	if request.Cols <= 0 {
+		request.Cols = 80
+	}
+
+	// The init container has exited by the time the server is up, there is nothing to exec into.
+	if request.ContainerName != "actlabs" && request.ContainerName != "caddy" {
+		return entity.TerminalSession{}, entity.ContainerExec{}, fmt.Errorf("%w: container must be actlabs or caddy", entity.ErrInvalidTerminalRequest)
+	}
+	if !helper.Contains(entity.TerminalCommands, request.Command) {
+		return entity.TerminalSession{}, entity.ContainerExec{}, fmt.Errorf("%w: command must be one of %s", entity.ErrInvalidTerminalRequest, helper.SliceToString(entity.TerminalCommands))
+	}
+
+	exec, err := t.serverRepository.ExecuteContainerCommand(server, request)
+	if err != nil {
+		slog.Error("Error:", err)
+		return entity.TerminalSession{}, entity.ContainerExec{}, err
+	}
+
+	session := entity.TerminalSession{
+		Id:                uuid.NewString(),
+		UserPrincipalId:   caller.PrincipalId,
+		UserPrincipalName: server.UserPrincipalName,
+		SubscriptionId:    server.SubscriptionId,
+		ContainerName:     request.ContainerName,
+		Command:           request.Command,
+		ClientIP:          caller.IP,
+		StartedAt:         helper.GetTodaysDateTimeISOString(),
+	}
+
+	t.record(session)
+
+	return session, exec, nil
+}
+
+func (t *terminalService) EndSession(session entity.TerminalSession, reason string) {
+	session.EndedAt = helper.GetTodaysDateTimeISOString()
+	session.EndReason = reason
+
+	t.record(session)
+}
+
+func (t *terminalService) record(session entity.TerminalSession) {
+	slog.Info("terminal session",
+		slog.String("sessionId", session.Id),
+		slog.String("userPrincipalName", session.UserPrincipalName),
+		slog.String("subscriptionId", session.SubscriptionId),
+		slog.String("container", session.ContainerName),
+		slog.String("command", session.Command),
+		slog.String("clientIp", session.ClientIP),
+		slog.String("startedAt", session.StartedAt),
+		slog.String("endedAt", session.EndedAt),
+		slog.String("endReason", session.EndReason),
+	)
+
+	if err := t.terminalRepository.RecordTerminalSession(session); err != nil {
+		slog.Error("not able to record terminal session", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/fake"
+	"errors"
+	"testing"
+)
+
+func TestStartSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  func(server *entity.Server)
+		wantErr  error
+		wantExec bool
+	}{
+		// The fake can't open exec sessions, getting that far is what is checked.
+		{name: "own server", wantErr: entity.ErrNotSupported, wantExec: true},
+		{name: "other user's server", request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }, wantErr: entity.ErrForbidden},
+		{name: "other subscription", request: func(server *entity.Server) { server.SubscriptionId = "attacker-subscription" }, wantErr: entity.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers := fake.NewServerRepository()
+			terminals := NewTerminalService(servers, nil, testConfig())
+
+			if err := servers.UpsertServerInDatabase(testServer()); err != nil {
+				t.Fatal(err)
+			}
+
+			request := testServer()
+			if tt.request != nil {
+				tt.request(&request)
+			}
+
+			_, _, err := terminals.StartSession(request, entity.TerminalRequest{}, testCaller(request))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StartSession() = %v, want %v", err, tt.wantErr)
+			}
+			if executed := servers.CallCount("ExecuteContainerCommand") > 0; executed != tt.wantExec {
+				t.Errorf("exec session opened = %v, want %v", executed, tt.wantExec)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/config"
+	"actlabs-managed-server/internal/entity"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const usageDateFormat = "2006-01-02"
+
+type usageService struct {
+	usageRepository entity.UsageRepository
+	servers         serverAccess
+	appConfig       *config.Config
+}
+
+func NewUsageService(
+	usageRepository entity.UsageRepository,
+	serverRepository entity.ServerRepository,
+	appConfig *config.Config,
+) entity.UsageService {
+	return &usageService{
+		usageRepository: usageRepository,
+		servers: serverAccess{
+			serverRepository: serverRepository,
+			appConfig:        appConfig,
+		},
+		appConfig: appConfig,
+	}
+}
+
+func (u *usageService) GetUsage(server entity.Server, caller entity.Caller, period string, from string, to string) (entity.UsageReport, error) {
+	if err := u.servers.Validate(server, caller); err != nil {
+		slog.Error("Error:", err)
+		return entity.UsageReport{}, err
+	}
+
+	record, err := u.servers.ownStoredServerRecord(server, caller)
+	if err != nil {
+		return entity.UsageReport{}, err
+	}
+
+	return u.report(record.UserPrincipalName, period, from, to)
+}
+
+func (u *usageService) GetUsageReport(caller entity.Caller, period string, from string, to string) (entity.UsageReport, error) {
+	if !caller.Can(entity.PermissionUsageRead) {
+		slog.Error("Error: reading the usage of all users needs the " + string(entity.PermissionUsageRead) + " permission")
+		return entity.UsageReport{}, entity.ErrForbidden
+	}
+
+	return u.report("", period, from, to)
+}
+
+// report adds up the intervals of the user, or of every user, by day or month. Intervals
+// still open count up to now.
+func (u *usageService) report(userPrincipalName string, period string, from string, to string) (entity.UsageReport, error) {
+	fromTime, toTime, err := usageRange(period, from, to)
+	if err != nil {
+		slog.Error("Error:", err)
+		return entity.UsageReport{}, err
+	}
+
+	intervals, err := u.usageRepository.ListUsageIntervals(entity.UsageQuery{
+		UserPrincipalName: userPrincipalName,
+		From:              fromTime,
+		To:                toTime,
+	})
+	if err != nil {
+		return entity.UsageReport{}, err
+	}
+
+	now := time.Now().UTC()
+	buckets := map[string]*entity.UsageBucket{}
+
+	for _, interval := range intervals {
+		start, err := time.Parse(entity.UsageTimeFormat, interval.Start)
+		if err != nil {
+			slog.Error("skipping usage interval with invalid start", slog.String("userPrincipalName", interval.UserPrincipalName), slog.String("start", interval.Start))
+			continue
+		}
+		end := now
+		if interval.End != "" {
+			if end, err = time.Parse(entity.UsageTimeFormat, interval.End); err != nil {
+				slog.Error("skipping usage interval with invalid end", slog.String("userPrincipalName", interval.UserPrincipalName), slog.String("end", interval.End))
+				continue
+			}
+		}
+
+		if start.Before(fromTime) {
+			start = fromTime
+		}
+		if end.After(toTime) {
+			end = toTime
+		}
+
+		price := u.appConfig.UsagePrices[interval.Backend]
+
+		// Split the interval at the day or month boundaries it crosses.
+		for start.Before(end) {
+			bucketStart, bucketEnd := usageBucket(period, start)
+			if bucketEnd.After(end) {
+				bucketEnd = end
+			}
+
+			key := interval.UserPrincipalName + "/" + usageBucketName(period, bucketStart)
+			bucket, ok := buckets[key]
+			if !ok {
+				bucket = &entity.UsageBucket{
+					UserPrincipalName: interval.UserPrincipalName,
+					Period:            usageBucketName(period, bucketStart),
+				}
+				buckets[key] = bucket
+			}
+
+			hours := bucketEnd.Sub(start).Hours()
+			bucket.Hours += hours
+			bucket.CPUHours += hours * interval.CPU
+			bucket.MemoryGBHours += hours * interval.Memory
+			bucket.Cost += hours * (interval.CPU*price.CPUHour + interval.Memory*price.MemoryGBHour)
+
+			start = bucketEnd
+		}
+	}
+
+	report := entity.UsageReport{
+		Period:   period,
+		From:     fromTime.Format(usageDateFormat),
+		To:       toTime.Format(usageDateFormat),
+		Currency: u.appConfig.UsageCurrency,
+		Buckets:  []entity.UsageBucket{},
+	}
+
+	for _, bucket := range buckets {
+		report.TotalCost += bucket.Cost
+		bucket.Hours = roundUsage(bucket.Hours)
+		bucket.CPUHours = roundUsage(bucket.CPUHours)
+		bucket.MemoryGBHours = roundUsage(bucket.MemoryGBHours)
+		bucket.Cost = roundUsage(bucket.Cost)
+		report.Buckets = append(report.Buckets, *bucket)
+	}
+	report.TotalCost = roundUsage(report.TotalCost)
+
+	sort.Slice(report.Buckets, func(i, j int) bool {
+		if report.Buckets[i].UserPrincipalName != report.Buckets[j].UserPrincipalName {
+			return report.Buckets[i].UserPrincipalName < report.Buckets[j].UserPrincipalName
+		}
+		return report.Buckets[i].Period < report.Buckets[j].Period
+	})
+
+	return report, nil
+}
+
+// usageRange parses the dates of the report, to is exclusive. Daily reports default to the
+// last 30 days and monthly reports to the last 12 months, both including today.
+func usageRange(period string, from string, to string) (time.Time, time.Time, error) {
+	if period != entity.UsagePeriodDaily && period != entity.UsagePeriodMonthly {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: period must be either %s or %s", entity.ErrInvalidUsageRequest, entity.UsagePeriodDaily, entity.UsagePeriodMonthly)
+	}
+
+	now := time.Now().UTC()
+	toTime := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if to != "" {
+		parsed, err := time.Parse(usageDateFormat, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: to must be a date like %s", entity.ErrInvalidUsageRequest, usageDateFormat)
+		}
+		toTime = parsed
+	}
+
+	fromTime := toTime.AddDate(0, 0, -30)
+	if period == entity.UsagePeriodMonthly {
+		fromTime = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0)
+	}
+	if from != "" {
+		parsed, err := time.Parse(usageDateFormat, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: from must be a date like %s", entity.ErrInvalidUsageRequest, usageDateFormat)
+		}
+		fromTime = parsed
+	}
+
+	if !fromTime.Before(toTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: from must be before to", entity.ErrInvalidUsageRequest)
+	}
+
+	return fromTime, toTime, nil
+}
+
+// usageBucket returns the start and end of the day or month the time is in.
+func usageBucket(period string, t time.Time) (time.Time, time.Time) {
+	if period == entity.UsagePeriodMonthly {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+func usageBucketName(period string, start time.Time) string {
+	if period == entity.UsagePeriodMonthly {
+		return start.Format("2006-01")
+	}
+	return start.Format(usageDateFormat)
+}
+
+func roundUsage(value float64) float64 {
+	return math.Round(value*10000) / 10000
+}
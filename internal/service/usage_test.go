@@ -0,0 +1,59 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"actlabs-managed-server/internal/fake"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		seed    bool
+		request func(server *entity.Server)
+		wantErr error
+	}{
+		{name: "own usage", seed: true},
+		{name: "other user's usage", seed: true, request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }, wantErr: entity.ErrForbidden},
+		{name: "no record", request: func(server *entity.Server) { server.UserPrincipalId = "attacker-oid" }, wantErr: entity.ErrServerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers := fake.NewServerRepository()
+			usage := fake.NewUsageRepository()
+			u := NewUsageService(usage, servers, testConfig())
+
+			if tt.seed {
+				record := testServer()
+				if err := servers.UpsertServerInDatabase(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			interval := entity.NewUsageInterval("user@example.com", time.Now().Add(-time.Hour))
+			interval.Backend = entity.BackendAzureContainerInstances
+			interval.CPU = 1
+			if err := usage.UpsertUsageInterval(interval); err != nil {
+				t.Fatal(err)
+			}
+
+			request := testServer()
+			if tt.request != nil {
+				tt.request(&request)
+			}
+
+			report, err := u.GetUsage(request, testCaller(request), entity.UsagePeriodDaily, "", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetUsage() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && len(report.Buckets) == 0 {
+				t.Errorf("buckets = none, want the user's usage")
+			}
+			if tt.wantErr != nil && len(report.Buckets) != 0 {
+				t.Errorf("buckets = %d, want none", len(report.Buckets))
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"actlabs-managed-server/internal/entity"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+// Worker runs long running operations in the background with bounded concurrency.
+type Worker struct {
+	jobs   chan job
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+// job is a queued operation. failed is called with the panic when run panics, so that the
+// operation doesn't stay running forever.
+type job struct {
+	run    func()
+	failed func(err error)
+}
+
+func NewWorker(concurrency int, queueSize int) *Worker {
+	w := &Worker{
+		jobs: make(chan job, queueSize),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		w.wg.Add(1)
+		go w.run()
+	}
+
+	return w
+}
+
+func (w *Worker) run() {
+	defer w.wg.Done()
+	for job := range w.jobs {
+		w.execute(job)
+	}
+}
+
+func (w *Worker) execute(job job) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("background job panicked", slog.Any("panic", r))
+			job.failed(fmt.Errorf("operation panicked: %v", r))
+		}
+	}()
+	job.run()
+}
+
+// Submit queues run without blocking, failed is called instead of crashing when run
+// panics. It fails if the queue is full or the worker is shutting down.
+func (w *Worker) Submit(run func(), failed func(err error)) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return errors.New("worker is shutting down")
+	}
+
+	select {
+	case w.jobs <- job{run: run, failed: failed}:
+		return nil
+	default:
+		return entity.ErrTooManyOperations
+	}
+}
+
+// Shutdown stops accepting jobs and waits for queued jobs to finish or the context to expire.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.jobs)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerFailsPanickingJob(t *testing.T) {
+	worker := NewWorker(1, 1)
+
+	var failed error
+	if err := worker.Submit(func() { panic("boom") }, func(err error) { failed = err }); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := worker.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if failed == nil || !strings.Contains(failed.Error(), "boom") {
+		t.Fatalf("failed with %v, want the panic", failed)
+	}
+}
@@ -31,6 +31,13 @@ for var in "${required_env_vars[@]}"; do
     fi
 done
 
+# The SQLite server store uses go-sqlite3, which needs cgo and a C compiler.
+if ! command -v "${CC:-gcc}" >/dev/null 2>&1; then
+    echo "A C compiler is needed to build with cgo, install gcc (e.g. apt-get install -y build-essential)"
+    exit 1
+fi
+export CGO_ENABLED=1
+
 go build -o actlabs-managed-server ./cmd/actlabs-managed-server
 
 docker build -t actlab.azurecr.io/actlabs-managed-server:${TAG} .
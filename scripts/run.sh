@@ -16,6 +16,13 @@ done
 
 rm ./tmp/main
 
+# The SQLite server store uses go-sqlite3, which needs cgo and a C compiler.
+if ! command -v "${CC:-gcc}" >/dev/null 2>&1; then
+    echo "A C compiler is needed to build with cgo, install gcc (e.g. apt-get install -y build-essential)"
+    exit 1
+fi
+export CGO_ENABLED=1
+
 go build -o ./tmp/main ./cmd/actlabs-managed-server
 
 export LOG_LEVEL="${LOG_LEVEL}" && export PORT="8883"
\ No newline at end of file